@@ -4,43 +4,106 @@ import (
 	"context"
 	"log/slog"
 	"os"
-	"strings"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
 )
 
 var KMS issuer.KMSAPI
-var signingKeyArn string
-var keyID string
+var SSM issuer.SSMAPI
+var keyrings = &issuer.KeyringReloader{}
+var tokenPolicy issuer.TokenPolicy
+var batchConcurrency = 1
 
 func main() {
 	baseConfig, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
 	KMS = kms.NewFromConfig(baseConfig)
+	SSM = ssm.NewFromConfig(baseConfig)
 
-	signingKeyArn = os.Getenv("SIGNING_KEY_ARN")
+	// Fail at init on a malformed key or replica ARN rather than on every
+	// request.
+	keyArn, err := issuer.ParseKMSKeyArn(os.Getenv(issuer.SigningKeyArnEnvVar))
+	if err != nil {
+		panic(err)
+	}
+	if _, err := issuer.CheckReplicaKeyArns(keyArn, issuer.ConfiguredReplicaKeyArns()); err != nil {
+		panic(err)
+	}
+
+	keyIDStrategy, err := issuer.ConfiguredKeyIDStrategy()
+	if err != nil {
+		panic(err)
+	}
+
+	verifySignatures := issuer.ConfiguredKMSSignatureVerification()
 
-	arnParts := strings.Split(signingKeyArn, "/")
-	if len(arnParts) == 2 {
-		keyID = arnParts[1]
+	digestMode, err := issuer.ConfiguredKMSDigestMode()
+	if err != nil {
+		panic(err)
+	}
+
+	loader := issuer.KeyLoader{
+		SSM:              SSM,
+		KMS:              KMS,
+		KeyIDStrategy:    keyIDStrategy,
+		KMSSignerOptions: []issuer.KMSSignerOption{issuer.WithSignatureVerification(verifySignatures), issuer.WithDigestMode(digestMode)},
+		KMSClientForRegion: func(region string) issuer.KMSAPI {
+			return kms.NewFromConfig(baseConfig, func(o *kms.Options) { o.Region = region })
+		},
+		KMSCircuitBreaker: &issuer.CircuitBreaker{},
 	}
 
-	lambda.StartHandlerFunc(issuer.HandlerWithLambdaLogging(handler))
+	tokenPolicy, err = issuer.ConfiguredTokenPolicy()
+	if err != nil {
+		panic(err)
+	}
+
+	// KMS signs each token in its own request, so a batch signs several at a time.
+	batchConcurrency, err = issuer.ConfiguredBatchConcurrency(10)
+	if err != nil {
+		panic(err)
+	}
+
+	refreshInterval, err := issuer.ConfiguredKeyRefreshInterval()
+	if err != nil {
+		panic(err)
+	}
+
+	// Keys are loaded on the first request, with retries, so a Parameter Store
+	// or KMS error fails that request rather than the execution environment.
+	keyrings = &issuer.KeyringReloader{
+		Loader:      &loader,
+		Custodian:   issuer.CustodianKMS,
+		Interval:    refreshInterval,
+		PublishJWKS: true,
+	}
+
+	lambda.StartHandlerFunc(issuer.HandlerWithLambdaLogging(issuer.SingleOrBatchHandler(handler, batchHandler)))
 }
 
 func handler(ctx context.Context, input issuer.JWTIssuerFunctionInput) (issuer.JWTIssuerFunctionOutput, error) {
 	defer issuer.LogWithTiming(ctx, slog.LevelDebug, "jwt_issuer_kms.handler", "input", input)()
 
-	token := issuer.PrepareToken(input, keyID)
+	keyring, err := keyrings.Keyring(ctx)
+	if err != nil {
+		return issuer.JWTIssuerFunctionOutput{}, issuer.InvokeError(err)
+	}
+
+	output, err := issuer.IssueJWT(ctx, keyring, tokenPolicy, input)
+
+	return output, issuer.InvokeError(err)
+}
+
+func batchHandler(ctx context.Context, inputs []issuer.JWTIssuerFunctionInput) ([]issuer.JWTIssuerBatchResult, error) {
+	defer issuer.LogWithTiming(ctx, slog.LevelDebug, "jwt_issuer_kms.batchHandler", "size", len(inputs))()
 
-	signedToken, err := issuer.SignJWTWithKMS(ctx, KMS, token, signingKeyArn)
+	keyring, err := keyrings.Keyring(ctx)
 	if err != nil {
-		return issuer.JWTIssuerFunctionOutput{}, err
+		return nil, issuer.InvokeError(err)
 	}
 
-	return issuer.JWTIssuerFunctionOutput{
-		Token: signedToken,
-	}, nil
+	return issuer.IssueJWTBatch(ctx, keyring, tokenPolicy, inputs, batchConcurrency), nil
 }
@@ -5,6 +5,7 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
+	"crypto/sha256"
 	_ "embed"
 	"testing"
 	"time"
@@ -13,6 +14,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
 	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -24,8 +27,8 @@ var privateKeyPEM []byte
 var publicKeyPEM []byte
 
 func Test_handler(t *testing.T) {
-	signingKeyArn = "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b"
-	keyID = "4a2c1b37-e4c8-466a-b873-11aaf144b01b"
+	signingKeyArn := "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b"
+	keyID := "4a2c1b37-e4c8-466a-b873-11aaf144b01b"
 
 	claims := jwt.MapClaims{
 		"exp": jwt.NewNumericDate(time.Now().Add(time.Minute)),
@@ -48,6 +51,16 @@ func Test_handler(t *testing.T) {
 	mockKMS.On("Sign", mock.Anything, mock.Anything).Return(&kms.SignOutput{Signature: signature}, nil)
 	KMS = &mockKMS
 
+	alg, err := issuer.LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+
+	signer, err := issuer.NewKMSSigner(KMS, alg, signingKeyArn, keyID, publicKeyObj)
+	require.NoError(t, err)
+
+	keyring, err := issuer.NewKeyring("", nil, signer)
+	require.NoError(t, err)
+	keyrings.SetKeyring(keyring)
+
 	output, err := handler(context.Background(), issuer.JWTIssuerFunctionInput{Claims: claims})
 	require.NoError(t, err)
 
@@ -56,4 +69,36 @@ func Test_handler(t *testing.T) {
 	}, jwt.WithValidMethods([]string{"ES256"}))
 
 	require.NoError(t, err)
+
+	t.Run("issues batches", func(t *testing.T) {
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("Sign", mock.Anything, mock.Anything).Return(func(_ context.Context, input *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+			digest := sha256.Sum256(input.Message)
+			signature, err := ecdsa.SignASN1(rand.Reader, privateKeyObj, digest[:])
+			return &kms.SignOutput{Signature: signature}, err
+		})
+
+		signer, err := issuer.NewKMSSigner(&mockKMS, alg, signingKeyArn, keyID, publicKeyObj)
+		require.NoError(t, err)
+		keyring, err := issuer.NewKeyring("", nil, signer)
+		require.NoError(t, err)
+		keyrings.SetKeyring(keyring)
+
+		results, err := batchHandler(context.Background(), []issuer.JWTIssuerFunctionInput{
+			{Claims: jwt.MapClaims{"sub": "user-1"}},
+			{Claims: jwt.MapClaims{"sub": "user-2"}, KeyID: lo.ToPtr("missing")},
+			{Claims: jwt.MapClaims{"sub": "user-3"}},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+		mockKMS.AssertNumberOfCalls(t, "Sign", 2)
+
+		for _, i := range []int{0, 2} {
+			_, err = jwt.Parse(results[i].Token, func(t *jwt.Token) (any, error) {
+				return publicKeyObj, nil
+			}, jwt.WithValidMethods([]string{"ES256"}))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, "KEY_NOT_FOUND", results[1].Error.Type)
+	})
 }
@@ -2,56 +2,90 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"log/slog"
 	"os"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
-	"github.com/samber/lo"
 )
 
+var KMS issuer.KMSAPI
 var SSM issuer.SSMAPI
-var privateKey *ecdsa.PrivateKey
-var keyID string
+var keyrings = &issuer.KeyringReloader{}
+var tokenPolicy issuer.TokenPolicy
+var batchConcurrency = 1
 
 func main() {
 	baseConfig, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
+	KMS = kms.NewFromConfig(baseConfig)
 	SSM = ssm.NewFromConfig(baseConfig)
 
-	getParamResponse, err := SSM.GetParameter(context.TODO(), &ssm.GetParameterInput{
-		Name:           lo.ToPtr(issuer.PrivateKeyParameterName()),
-		WithDecryption: lo.ToPtr(true),
-	})
+	keyIDStrategy, err := issuer.ConfiguredKeyIDStrategy()
 	if err != nil {
 		panic(err)
 	}
 
-	key, err := jwt.ParseECPrivateKeyFromPEM([]byte(lo.FromPtr(getParamResponse.Parameter.Value)))
+	loader := issuer.KeyLoader{
+		SSM:           SSM,
+		KMS:           KMS,
+		KeyIDStrategy: keyIDStrategy,
+		KMSClientForRegion: func(region string) issuer.KMSAPI {
+			return kms.NewFromConfig(baseConfig, func(o *kms.Options) { o.Region = region })
+		},
+		KMSCircuitBreaker: &issuer.CircuitBreaker{},
+	}
+
+	tokenPolicy, err = issuer.ConfiguredTokenPolicy()
 	if err != nil {
 		panic(err)
 	}
 
-	privateKey = key
-	keyID = issuer.ParameterStoreKeyID()
+	// Tokens are signed in-process, so a batch signs them one at a time by default.
+	batchConcurrency, err = issuer.ConfiguredBatchConcurrency(1)
+	if err != nil {
+		panic(err)
+	}
+
+	refreshInterval, err := issuer.ConfiguredKeyRefreshInterval()
+	if err != nil {
+		panic(err)
+	}
 
-	lambda.StartHandlerFunc(issuer.HandlerWithLambdaLogging(handler))
+	// Keys are loaded on the first request, with retries, so a Parameter Store
+	// or KMS error fails that request rather than the execution environment.
+	keyrings = &issuer.KeyringReloader{
+		Loader:      &loader,
+		Custodian:   issuer.CustodianParameterStore,
+		Interval:    refreshInterval,
+		PublishJWKS: true,
+	}
+
+	lambda.StartHandlerFunc(issuer.HandlerWithLambdaLogging(issuer.SingleOrBatchHandler(handler, batchHandler)))
 }
 
 func handler(ctx context.Context, input issuer.JWTIssuerFunctionInput) (issuer.JWTIssuerFunctionOutput, error) {
 	defer issuer.LogWithTiming(ctx, slog.LevelDebug, "jwt_issuer_parameter_store.handler", "input", input)()
 
-	token := issuer.PrepareToken(input, keyID)
+	keyring, err := keyrings.Keyring(ctx)
+	if err != nil {
+		return issuer.JWTIssuerFunctionOutput{}, issuer.InvokeError(err)
+	}
+
+	output, err := issuer.IssueJWT(ctx, keyring, tokenPolicy, input)
+
+	return output, issuer.InvokeError(err)
+}
+
+func batchHandler(ctx context.Context, inputs []issuer.JWTIssuerFunctionInput) ([]issuer.JWTIssuerBatchResult, error) {
+	defer issuer.LogWithTiming(ctx, slog.LevelDebug, "jwt_issuer_parameter_store.batchHandler", "size", len(inputs))()
 
-	signedToken, err := token.SignedString(privateKey)
+	keyring, err := keyrings.Keyring(ctx)
 	if err != nil {
-		return issuer.JWTIssuerFunctionOutput{}, err
+		return nil, issuer.InvokeError(err)
 	}
 
-	return issuer.JWTIssuerFunctionOutput{
-		Token: signedToken,
-	}, nil
+	return issuer.IssueJWTBatch(ctx, keyring, tokenPolicy, inputs, batchConcurrency), nil
 }
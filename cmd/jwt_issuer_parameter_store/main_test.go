@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-lambda-go/lambda/messages"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -20,7 +24,7 @@ var privateKeyPEM []byte
 var publicKeyPEM []byte
 
 func Test_handler(t *testing.T) {
-	keyID = "4a2c1b37"
+	keyID := "4a2c1b37"
 
 	claims := jwt.MapClaims{
 		"foo": "bar",
@@ -28,12 +32,20 @@ func Test_handler(t *testing.T) {
 
 	privateKeyObj, err := jwt.ParseECPrivateKeyFromPEM(privateKeyPEM)
 	require.NoError(t, err)
-	privateKey = privateKeyObj
+	alg, err := issuer.LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+
+	signer, err := issuer.NewLocalSigner(alg, privateKeyObj, keyID)
+	require.NoError(t, err)
+
+	keyring, err := issuer.NewKeyring("", nil, signer)
+	require.NoError(t, err)
+	keyrings.SetKeyring(keyring)
 
 	publicKeyObj, err := jwt.ParseECPublicKeyFromPEM(publicKeyPEM)
 	require.NoError(t, err)
 
-	output, err := handler(context.Background(), issuer.JWTIssuerFunctionInput{Claims: claims, TTL: lo.ToPtr(int64(60)), SetIat: lo.ToPtr(true), SetJti: lo.ToPtr(true)})
+	output, err := handler(context.Background(), issuer.JWTIssuerFunctionInput{Claims: claims, TTL: lo.ToPtr(issuer.Duration("60")), SetIat: lo.ToPtr(true), SetJti: lo.ToPtr(true)})
 	require.NoError(t, err)
 
 	generatedToken, err := jwt.Parse(output.Token, func(t *jwt.Token) (any, error) {
@@ -48,4 +60,63 @@ func Test_handler(t *testing.T) {
 	assert.Greater(t, generatedClaims["exp"], float64(time.Now().Unix()))
 	assert.LessOrEqual(t, generatedClaims["iat"], float64(time.Now().Unix()))
 	assert.Len(t, generatedClaims["jti"], 36)
+
+	t.Run("selects keys by kid or profile", func(t *testing.T) {
+		rsaAlg, err := issuer.LookupSigningAlgorithm("RS256")
+		require.NoError(t, err)
+		rsaKey, err := rsaAlg.GenerateKey()
+		require.NoError(t, err)
+		rsaSigner, err := issuer.NewLocalSigner(rsaAlg, rsaKey, "rsa-key")
+		require.NoError(t, err)
+
+		keyring, err := issuer.NewKeyring(keyID, map[string]string{"legacy": "rsa-key"}, signer, rsaSigner)
+		require.NoError(t, err)
+		keyrings.SetKeyring(keyring)
+
+		for _, input := range []issuer.JWTIssuerFunctionInput{
+			{Claims: jwt.MapClaims{}, KeyID: lo.ToPtr("rsa-key")},
+			{Claims: jwt.MapClaims{}, Profile: lo.ToPtr("legacy")},
+		} {
+			output, err := handler(context.Background(), input)
+			require.NoError(t, err)
+
+			generatedToken, err := jwt.Parse(output.Token, func(t *jwt.Token) (any, error) {
+				return rsaKey.Public(), nil
+			}, jwt.WithValidMethods([]string{"RS256"}))
+			require.NoError(t, err)
+			assert.Equal(t, "rsa-key", generatedToken.Header["kid"])
+		}
+
+		output, err := handler(context.Background(), issuer.JWTIssuerFunctionInput{Claims: jwt.MapClaims{}})
+		require.NoError(t, err)
+		generatedToken, err := jwt.Parse(output.Token, func(t *jwt.Token) (any, error) {
+			return publicKeyObj, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		require.NoError(t, err)
+		assert.Equal(t, keyID, generatedToken.Header["kid"])
+
+		_, err = handler(context.Background(), issuer.JWTIssuerFunctionInput{KeyID: lo.ToPtr("unknown")})
+		assert.Equal(t, messages.InvokeResponse_Error{Type: "KEY_NOT_FOUND", Message: `issuer: signing key not found: unknown kid "unknown"`}, err)
+
+		_, err = handler(context.Background(), issuer.JWTIssuerFunctionInput{KeyID: lo.ToPtr("rsa-key"), Profile: lo.ToPtr("legacy")})
+		assert.Equal(t, messages.InvokeResponse_Error{Type: "INVALID_INPUT", Message: "issuer: specify either kid or profile, not both"}, err)
+	})
+
+	t.Run("returns an unavailable error when keys can't be loaded", func(t *testing.T) {
+		mockSSM := mocks.SSMAPI{}
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(nil, errors.New("ThrottlingException"))
+
+		keyrings = &issuer.KeyringReloader{
+			Loader:    &issuer.KeyLoader{SSM: &mockSSM},
+			Custodian: issuer.CustodianParameterStore,
+			Backoff:   time.Millisecond,
+		}
+
+		_, err := handler(context.Background(), issuer.JWTIssuerFunctionInput{Claims: claims})
+		var invokeErr messages.InvokeResponse_Error
+		require.True(t, errors.As(err, &invokeErr))
+		assert.Equal(t, "SIGNER_UNAVAILABLE", invokeErr.Type)
+		assert.Contains(t, invokeErr.Message, "after 3 attempts")
+		mockSSM.AssertNumberOfCalls(t, "GetParameter", 3)
+	})
 }
@@ -2,12 +2,8 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -16,16 +12,19 @@ import (
 	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
 	"github.com/samber/lo"
 )
 
+var KMS issuer.KMSAPI
 var SSM issuer.SSMAPI
 
 func main() {
 	baseConfig, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
+	KMS = kms.NewFromConfig(baseConfig)
 	SSM = ssm.NewFromConfig(baseConfig)
 
 	lambda.Start(cfn.LambdaWrap(issuer.CloudFormationHandlerWithLambdaLogging(handler)))
@@ -38,17 +37,44 @@ func handler(ctx context.Context, event cfn.Event) (physicalResourceID string, d
 
 	switch event.RequestType {
 	case cfn.RequestCreate:
-		privateKeyPEM, publicKeyPEM := generateKeyPair()
+		var alg issuer.SigningAlgorithm
+		alg, err = issuer.ConfiguredSigningAlgorithm()
+		if err != nil {
+			return
+		}
+
+		var privateKeyPEM, publicKeyPEM []byte
+		privateKeyPEM, publicKeyPEM, err = generateKeyPair(alg)
+		if err != nil {
+			return
+		}
+
 		err = createParameters(ctx, privateKeyPEM, publicKeyPEM)
-		data = map[string]any{
-			"KeyArn":             "",
-			"KeyID":              issuer.ParameterStoreKeyID(),
-			"PublicKeyPEMBase64": base64.StdEncoding.EncodeToString(publicKeyPEM),
-			"SigningMethod":      "ES256",
+		if err != nil {
+			return
 		}
+
+		data, err = publishKey(ctx, alg, publicKeyPEM)
 		return
 	case cfn.RequestUpdate:
-		// no-op
+		// Keys are never regenerated on update, but the outputs and JWK Set are
+		// refreshed from the existing public key.
+		var alg issuer.SigningAlgorithm
+		alg, err = issuer.ConfiguredSigningAlgorithm()
+		if err != nil {
+			return
+		}
+
+		var getParamResponse *ssm.GetParameterOutput
+		getParamResponse, err = SSM.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           lo.ToPtr(issuer.PublicKeyParameterName()),
+			WithDecryption: lo.ToPtr(true),
+		})
+		if err != nil {
+			return
+		}
+
+		data, err = publishKey(ctx, alg, []byte(lo.FromPtr(getParamResponse.Parameter.Value)))
 		return
 	case cfn.RequestDelete:
 		deleteParameters(ctx)
@@ -57,17 +83,73 @@ func handler(ctx context.Context, event cfn.Event) (physicalResourceID string, d
 	return
 }
 
-func generateKeyPair() (privateKeyPEM []byte, publicKeyPEM []byte) {
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+// publishKey writes the JWK Set for the public key to Parameter Store and
+// returns the custom resource output attributes.
+func publishKey(ctx context.Context, alg issuer.SigningAlgorithm, publicKeyPEM []byte) (map[string]any, error) {
+	publicKey, err := issuer.ParsePublicKeyPEM(publicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := alg.CheckPublicKey(publicKey); err != nil {
+		return nil, err
+	}
+
+	keyIDStrategy, err := issuer.ConfiguredKeyIDStrategy()
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := keyIDStrategy.KeyID(issuer.ParameterStoreKeyID(), publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := issuer.NewJWK(publicKey, keyID, alg.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	// Stacks with a keyring publish every key in it, not just the stack's key.
+	loader := issuer.KeyLoader{SSM: SSM, KMS: KMS, KeyIDStrategy: keyIDStrategy}
+	jwks, err := loader.ConfiguredJWKSet(ctx, issuer.JWKSet{Keys: []issuer.JWK{jwk}})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := issuer.PutJWKSParameter(ctx, SSM, jwks); err != nil {
+		return nil, err
+	}
+
+	jwksJSON, err := json.Marshal(jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"JWKS":               string(jwksJSON),
+		"KeyArn":             "",
+		"KeyID":              keyID,
+		"PublicKeyPEMBase64": base64.StdEncoding.EncodeToString(publicKeyPEM),
+		"SigningMethod":      alg.Name(),
+	}, nil
+}
+
+func generateKeyPair(alg issuer.SigningAlgorithm) (privateKeyPEM []byte, publicKeyPEM []byte, err error) {
+	privateKey, err := alg.GenerateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeyPEM, err = issuer.EncodePrivateKeyPEM(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKeyPEM, err = issuer.EncodePublicKeyPEM(privateKey.Public())
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
-	x509Private, _ := x509.MarshalPKCS8PrivateKey(privateKey)
-	privateKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: x509Private})
-	x509Public, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
-	publicKeyPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509Public})
 
-	return privateKeyPEM, publicKeyPEM
+	return privateKeyPEM, publicKeyPEM, nil
 }
 
 func createParameters(ctx context.Context, privateKeyPEM []byte, publicKeyPEM []byte) error {
@@ -121,6 +203,8 @@ func deleteParameters(ctx context.Context) error {
 		Names: []string{
 			issuer.PrivateKeyParameterName(),
 			issuer.PublicKeyParameterName(),
+			issuer.JWKSParameterName(),
+			issuer.KeyringParameterName(),
 		},
 	})
 	if err != nil {
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"os"
 	"testing"
@@ -10,11 +14,13 @@ import (
 	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
 	"github.com/hotsock/jwt-issuer/internal/mocks"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 //go:embed cloudformation-input.json
@@ -26,13 +32,27 @@ func Test_handler(t *testing.T) {
 	var event cfn.Event
 	json.Unmarshal(cloudformationInput, &event)
 
-	t.Run("update requests no-op", func(t *testing.T) {
+	t.Run("update requests do not regenerate keys", func(t *testing.T) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		publicKeyPEM, err := issuer.EncodePublicKeyPEM(&privateKey.PublicKey)
+		require.NoError(t, err)
+
 		mockSSM := mockedSSM()
+		mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.PublicKeyParameterName())).Return(&ssm.GetParameterOutput{
+			Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(string(publicKeyPEM))},
+		}, nil)
 		SSM = mockSSM
 
 		event.RequestType = cfn.RequestUpdate
-		handler(context.Background(), event)
-		mockSSM.AssertNotCalled(t, "PutParameter", mock.Anything, mock.Anything)
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+
+		mockSSM.AssertNumberOfCalls(t, "PutParameter", 1)
+		call := mockSSM.Calls[2].Arguments[1].(*ssm.PutParameterInput)
+		assert.Equal(t, issuer.JWKSParameterName(), lo.FromPtr(call.Name))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(publicKeyPEM), data["PublicKeyPEMBase64"])
+		assert.Equal(t, lo.FromPtr(call.Value), data["JWKS"])
 	})
 
 	t.Run("delete requests delete parameters", func(t *testing.T) {
@@ -50,32 +70,135 @@ func Test_handler(t *testing.T) {
 
 		event.RequestType = cfn.RequestCreate
 		handler(context.Background(), event)
-		mockSSM.AssertNumberOfCalls(t, "PutParameter", 2)
+		mockSSM.AssertNumberOfCalls(t, "PutParameter", 3)
 
 		call1 := mockSSM.Calls[0].Arguments[1].(*ssm.PutParameterInput)
 		call2 := mockSSM.Calls[1].Arguments[1].(*ssm.PutParameterInput)
+		call3 := mockSSM.Calls[3].Arguments[1].(*ssm.PutParameterInput)
 
 		assert.Equal(t, issuer.PrivateKeyParameterName(), lo.FromPtr(call1.Name))
 		assert.Len(t, lo.FromPtr(call1.Value), 247)
 		assert.Equal(t, issuer.PublicKeyParameterName(), lo.FromPtr(call2.Name))
 		assert.Len(t, lo.FromPtr(call2.Value), 178)
 		assert.NotEqual(t, lo.FromPtr(call1.Value), lo.FromPtr(call2.Value))
+		assert.Equal(t, issuer.JWKSParameterName(), lo.FromPtr(call3.Name))
+
+		var jwks issuer.JWKSet
+		require.NoError(t, json.Unmarshal([]byte(lo.FromPtr(call3.Value)), &jwks))
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, "EC", jwks.Keys[0].KeyType)
+		assert.Equal(t, "P-256", jwks.Keys[0].Curve)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", jwks.Keys[0].KeyID)
+		assert.Equal(t, "ES256", jwks.Keys[0].Algorithm)
+		assert.Equal(t, "sig", jwks.Keys[0].Use)
+	})
+
+	t.Run("create requests generate keys for the configured signing algorithm", func(t *testing.T) {
+		t.Setenv(issuer.SigningAlgorithmEnvVar, "ES384")
+		mockSSM := mockedSSM()
+		SSM = mockSSM
+
+		event.RequestType = cfn.RequestCreate
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.Equal(t, "ES384", data["SigningMethod"])
+
+		call1 := mockSSM.Calls[0].Arguments[1].(*ssm.PutParameterInput)
+		privateKey, err := jwt.ParseECPrivateKeyFromPEM([]byte(lo.FromPtr(call1.Value)))
+		require.NoError(t, err)
+		assert.Equal(t, "P-384", privateKey.Curve.Params().Name)
+	})
+
+	t.Run("create requests generate RSA keys of the configured size", func(t *testing.T) {
+		t.Setenv(issuer.SigningAlgorithmEnvVar, "PS256")
+		t.Setenv(issuer.RSAKeySpecEnvVar, "RSA_3072")
+		mockSSM := mockedSSM()
+		SSM = mockSSM
+
+		event.RequestType = cfn.RequestCreate
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.Equal(t, "PS256", data["SigningMethod"])
+
+		call1 := mockSSM.Calls[0].Arguments[1].(*ssm.PutParameterInput)
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(lo.FromPtr(call1.Value)))
+		require.NoError(t, err)
+		assert.Equal(t, 3072, privateKey.N.BitLen())
+	})
+
+	t.Run("create requests generate Ed25519 keys for EdDSA", func(t *testing.T) {
+		t.Setenv(issuer.SigningAlgorithmEnvVar, "EdDSA")
+		mockSSM := mockedSSM()
+		SSM = mockSSM
+
+		event.RequestType = cfn.RequestCreate
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.Equal(t, "EdDSA", data["SigningMethod"])
+
+		call1 := mockSSM.Calls[0].Arguments[1].(*ssm.PutParameterInput)
+		_, err = jwt.ParseEdPrivateKeyFromPEM([]byte(lo.FromPtr(call1.Value)))
+		require.NoError(t, err)
+	})
+
+	t.Run("publishes every key in the keyring", func(t *testing.T) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		publicKeyPEM, err := issuer.EncodePublicKeyPEM(&privateKey.PublicKey)
+		require.NoError(t, err)
+
+		mockSSM := mocks.SSMAPI{}
+		mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.KeyringParameterName())).Return(&ssm.GetParameterOutput{
+			Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(`{"activeKeyId":"next","keys":[{"kid":"next","custodian":"ParameterStore","publicKeyParameter":"/next/public-key"},{"custodian":"ParameterStore","publicKeyParameter":"` + issuer.PublicKeyParameterName() + `"}]}`)},
+		}, nil)
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(&ssm.GetParameterOutput{
+			Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(string(publicKeyPEM))},
+		}, nil)
+		mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, nil)
+		SSM = &mockSSM
+
+		event.RequestType = cfn.RequestUpdate
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+
+		var jwks issuer.JWKSet
+		require.NoError(t, json.Unmarshal([]byte(data["JWKS"].(string)), &jwks))
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, "next", jwks.Keys[0].KeyID)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", jwks.Keys[1].KeyID)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", data["KeyID"])
 	})
 
 	t.Run("create requests no-op parameter store write if parameters already exist", func(t *testing.T) {
+		isJWKSParameter := mock.MatchedBy(func(input *ssm.PutParameterInput) bool {
+			return lo.FromPtr(input.Name) == issuer.JWKSParameterName()
+		})
+		isKeyParameter := mock.MatchedBy(func(input *ssm.PutParameterInput) bool {
+			return lo.FromPtr(input.Name) != issuer.JWKSParameterName()
+		})
+
 		mockSSM := mocks.SSMAPI{}
-		mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, &ssmtypes.ParameterAlreadyExists{Message: lo.ToPtr("parameter already exists")})
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(nil, &ssmtypes.ParameterNotFound{})
+		mockSSM.On("PutParameter", mock.Anything, isKeyParameter).Return(nil, &ssmtypes.ParameterAlreadyExists{Message: lo.ToPtr("parameter already exists")})
+		mockSSM.On("PutParameter", mock.Anything, isJWKSParameter).Return(nil, nil)
 		SSM = &mockSSM
 
 		event.RequestType = cfn.RequestCreate
 		handler(context.Background(), event)
-		mockSSM.AssertNumberOfCalls(t, "PutParameter", 2)
+		mockSSM.AssertNumberOfCalls(t, "PutParameter", 3)
 	})
 }
 
 func mockedSSM() *mocks.SSMAPI {
 	mockSSM := mocks.SSMAPI{}
+	mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.KeyringParameterName())).Return(nil, &ssmtypes.ParameterNotFound{})
 	mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, nil)
 	mockSSM.On("DeleteParameters", mock.Anything, mock.Anything).Return(nil, nil)
 	return &mockSSM
 }
+
+func isParameter(name string) any {
+	return mock.MatchedBy(func(input *ssm.GetParameterInput) bool {
+		return lo.FromPtr(input.Name) == name
+	})
+}
@@ -4,24 +4,27 @@ import (
 	"context"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"log/slog"
 	"os"
-	"strings"
 
 	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/hotsock/jwt-issuer/internal/issuer"
 	"github.com/samber/lo"
 )
 
 var KMS issuer.KMSAPI
+var SSM issuer.SSMAPI
 
 func main() {
 	baseConfig, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
 	KMS = kms.NewFromConfig(baseConfig)
+	SSM = ssm.NewFromConfig(baseConfig)
 
 	lambda.Start(cfn.LambdaWrap(issuer.CloudFormationHandlerWithLambdaLogging(handler)))
 }
@@ -31,8 +34,18 @@ func handler(ctx context.Context, event cfn.Event) (physicalResourceID string, d
 
 	physicalResourceID = "KeyInfoLoader"
 
+	if event.RequestType == cfn.RequestDelete {
+		deleteParameters(ctx)
+		return
+	}
+
+	signingKeyArn, err := issuer.ResolveKMSKeyArn(ctx, KMS, os.Getenv(issuer.SigningKeyArnEnvVar))
+	if err != nil {
+		return
+	}
+
 	publicKeyOutput, err := KMS.GetPublicKey(ctx, &kms.GetPublicKeyInput{
-		KeyId: lo.ToPtr(os.Getenv("SIGNING_KEY_ARN")),
+		KeyId: lo.ToPtr(signingKeyArn.String()),
 	})
 
 	if err != nil {
@@ -40,19 +53,84 @@ func handler(ctx context.Context, event cfn.Event) (physicalResourceID string, d
 	}
 
 	keyArn := lo.FromPtr(publicKeyOutput.KeyId)
-	arnParts := strings.Split(keyArn, "/")
-	keyID := arnParts[1]
-	publicKey, _ := x509.ParsePKIXPublicKey(publicKeyOutput.PublicKey)
+	resourceID, err := issuer.KMSKeyResourceID(keyArn)
+	if err != nil {
+		return
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(publicKeyOutput.PublicKey)
+	if err != nil {
+		return
+	}
+
+	keyIDStrategy, err := issuer.ConfiguredKeyIDStrategy()
+	if err != nil {
+		return
+	}
+
+	keyID, err := keyIDStrategy.KeyID(resourceID, publicKey)
+	if err != nil {
+		return
+	}
+
+	alg, err := issuer.ConfiguredSigningAlgorithm()
+	if err != nil {
+		return
+	}
+
+	if err = issuer.CheckKMSKey(alg, publicKeyOutput); err != nil {
+		return
+	}
+
+	if err = alg.CheckPublicKey(publicKey); err != nil {
+		return
+	}
+
 	x509Public, _ := x509.MarshalPKIXPublicKey(publicKey)
 	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x509Public})
 	publicKeyPEMBase64 := base64.StdEncoding.EncodeToString(publicKeyPEM)
 
+	jwk, err := issuer.NewJWK(publicKey, keyID, alg.Name())
+	if err != nil {
+		return
+	}
+
+	// Stacks with a keyring publish every key in it, not just the stack's key.
+	loader := issuer.KeyLoader{SSM: SSM, KMS: KMS, KeyIDStrategy: keyIDStrategy}
+	jwks, err := loader.ConfiguredJWKSet(ctx, issuer.JWKSet{Keys: []issuer.JWK{jwk}})
+	if err != nil {
+		return
+	}
+
+	if err = issuer.PutJWKSParameter(ctx, SSM, jwks); err != nil {
+		return
+	}
+
+	jwksJSON, err := json.Marshal(jwks)
+	if err != nil {
+		return
+	}
+
 	data = map[string]any{
+		"JWKS":               string(jwksJSON),
 		"KeyArn":             keyArn,
 		"KeyID":              keyID,
 		"PublicKeyPEMBase64": publicKeyPEMBase64,
-		"SigningMethod":      "ES256",
+		"SigningMethod":      alg.Name(),
 	}
 
 	return
 }
+
+func deleteParameters(ctx context.Context) error {
+	_, err := SSM.DeleteParameters(ctx, &ssm.DeleteParametersInput{
+		Names: []string{
+			issuer.JWKSParameterName(),
+			issuer.KeyringParameterName(),
+		},
+	})
+	if err != nil {
+		slog.Error("key_info_loader.deleteParameters", "error", err)
+	}
+	return err
+}
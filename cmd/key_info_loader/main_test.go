@@ -9,6 +9,10 @@ import (
 
 	"github.com/aws/aws-lambda-go/cfn"
 	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hotsock/jwt-issuer/internal/issuer"
 	"github.com/hotsock/jwt-issuer/internal/mocks"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/assert"
@@ -24,16 +28,26 @@ const kmsPublicKeyResponse = "MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAE/qZBAS8rW1+QG5
 func Test_handler(t *testing.T) {
 	var event cfn.Event
 	require.NoError(t, json.Unmarshal(cloudformationInput, &event))
+	t.Setenv(issuer.SigningKeyArnEnvVar, event.ResourceProperties["KeyArn"].(string))
 
 	mockKMS := mocks.KMSAPI{}
 	kmsPublicKey, _ := base64.StdEncoding.DecodeString(kmsPublicKeyResponse)
 	kmsOutput := &kms.GetPublicKeyOutput{
-		KeyId:     lo.ToPtr(event.ResourceProperties["KeyArn"].(string)),
-		PublicKey: []byte(kmsPublicKey),
+		KeyId:             lo.ToPtr(event.ResourceProperties["KeyArn"].(string)),
+		KeySpec:           kmstypes.KeySpecEccNistP256,
+		KeyUsage:          kmstypes.KeyUsageTypeSignVerify,
+		PublicKey:         []byte(kmsPublicKey),
+		SigningAlgorithms: []kmstypes.SigningAlgorithmSpec{kmstypes.SigningAlgorithmSpecEcdsaSha256},
 	}
 	mockKMS.On("GetPublicKey", mock.Anything, mock.Anything).Return(kmsOutput, nil)
 	KMS = &mockKMS
 
+	mockSSM := mocks.SSMAPI{}
+	mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(nil, &ssmtypes.ParameterNotFound{})
+	mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, nil)
+	mockSSM.On("DeleteParameters", mock.Anything, mock.Anything).Return(nil, nil)
+	SSM = &mockSSM
+
 	physicalResourceID, data, err := handler(context.Background(), event)
 	require.NoError(t, err)
 
@@ -42,4 +56,68 @@ func Test_handler(t *testing.T) {
 	assert.Equal(t, "c662cc14-a835-4e28-b6c1-0c77126d98b9", data["KeyID"])
 	assert.Equal(t, "LS0tLS1CRUdJTiBQVUJMSUMgS0VZLS0tLS0KTUZrd0V3WUhLb1pJemowQ0FRWUlLb1pJemowREFRY0RRZ0FFL3FaQkFTOHJXMStRRzVCUnBNZEYvaGYrWnNCLwpRWjAvRU93RDVVTTJsMkt4eHY3NlJiT1R0eDNIMVpSUDZwcHh0L29DNVB2eTBwK2crYTBXb0Y0R1hRPT0KLS0tLS1FTkQgUFVCTElDIEtFWS0tLS0tCg==", data["PublicKeyPEMBase64"])
 	assert.Equal(t, "ES256", data["SigningMethod"])
+	assert.JSONEq(t, `{"keys":[{"kty":"EC","crv":"P-256","x":"_qZBAS8rW1-QG5BRpMdF_hf-ZsB_QZ0_EOwD5UM2l2I","y":"scb--kWzk7cdx9WUT-qacbf6AuT78tKfoPmtFqBeBl0","kid":"c662cc14-a835-4e28-b6c1-0c77126d98b9","alg":"ES256","use":"sig"}]}`, data["JWKS"].(string))
+
+	putCall := mockSSM.Calls[1].Arguments[1].(*ssm.PutParameterInput)
+	assert.Equal(t, issuer.JWKSParameterName(), lo.FromPtr(putCall.Name))
+	assert.Equal(t, data["JWKS"], lo.FromPtr(putCall.Value))
+
+	t.Run("thumbprint key IDs", func(t *testing.T) {
+		t.Setenv(issuer.KeyIDStrategyEnvVar, "Thumbprint")
+		event.RequestType = cfn.RequestCreate
+
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.Equal(t, "uuWXznjsbl3pZjcp00RNt3mvjm5PQz84ikN7IivAgII", data["KeyID"])
+	})
+
+	t.Run("resolves alias ARNs", func(t *testing.T) {
+		t.Setenv(issuer.SigningKeyArnEnvVar, "arn:aws:kms:us-east-1:111111111111:alias/jwt-issuer")
+		mockKMS.On("DescribeKey", mock.Anything, mock.Anything).Return(&kms.DescribeKeyOutput{
+			KeyMetadata: &kmstypes.KeyMetadata{Arn: kmsOutput.KeyId},
+		}, nil).Once()
+		event.RequestType = cfn.RequestUpdate
+
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		assert.Equal(t, "c662cc14-a835-4e28-b6c1-0c77126d98b9", data["KeyID"])
+
+		getPublicKeyInput := mockKMS.Calls[len(mockKMS.Calls)-1].Arguments[1].(*kms.GetPublicKeyInput)
+		assert.Equal(t, "arn:aws:kms:us-east-1:111111111111:key/c662cc14-a835-4e28-b6c1-0c77126d98b9", lo.FromPtr(getPublicKeyInput.KeyId))
+	})
+
+	t.Run("fails on malformed key ARNs", func(t *testing.T) {
+		t.Setenv(issuer.SigningKeyArnEnvVar, "arn:aws:kms:us-east-1:111111111111:c662cc14-a835-4e28-b6c1-0c77126d98b9")
+		event.RequestType = cfn.RequestUpdate
+
+		_, _, err := handler(context.Background(), event)
+		assert.ErrorContains(t, err, "malformed KMS key ARN")
+	})
+
+	t.Run("publishes every key in the keyring", func(t *testing.T) {
+		keyringSSM := mocks.SSMAPI{}
+		keyringSSM.On("GetParameter", mock.Anything, mock.Anything).Return(&ssm.GetParameterOutput{
+			Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(`{"keys":[{"custodian":"KMS","keyArn":"arn:aws:kms:us-east-1:111111111111:key/c662cc14-a835-4e28-b6c1-0c77126d98b9"},{"kid":"next","custodian":"KMS","keyArn":"arn:aws:kms:us-east-1:111111111111:key/0f6b8c0e-3d5f-4b7a-9a47-b1b5a1f0f7a2"}]}`)},
+		}, nil)
+		keyringSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, nil)
+		SSM = &keyringSSM
+		defer func() { SSM = &mockSSM }()
+
+		event.RequestType = cfn.RequestUpdate
+		_, data, err := handler(context.Background(), event)
+		require.NoError(t, err)
+
+		var jwks issuer.JWKSet
+		require.NoError(t, json.Unmarshal([]byte(data["JWKS"].(string)), &jwks))
+		require.Len(t, jwks.Keys, 2)
+		assert.Equal(t, "c662cc14-a835-4e28-b6c1-0c77126d98b9", jwks.Keys[0].KeyID)
+		assert.Equal(t, "next", jwks.Keys[1].KeyID)
+	})
+
+	t.Run("delete requests delete the JWK Set parameter", func(t *testing.T) {
+		event.RequestType = cfn.RequestDelete
+		_, _, err := handler(context.Background(), event)
+		require.NoError(t, err)
+		mockSSM.AssertCalled(t, "DeleteParameters", mock.Anything, mock.Anything)
+	})
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/hotsock/jwt-issuer/internal/issuer"
+)
+
+var KMS issuer.KMSAPI
+var SSM issuer.SSMAPI
+
+func main() {
+	baseConfig, _ := config.LoadDefaultConfig(context.TODO(), config.WithRegion(os.Getenv("AWS_REGION")))
+	KMS = kms.NewFromConfig(baseConfig)
+	SSM = ssm.NewFromConfig(baseConfig)
+
+	lambda.StartHandlerFunc(issuer.HandlerWithLambdaLogging(handler))
+}
+
+func handler(ctx context.Context, event events.EventBridgeEvent) (issuer.RotationResult, error) {
+	defer issuer.LogWithTiming(ctx, slog.LevelInfo, "key_rotator.handler", "event", event)()
+
+	alg, err := issuer.ConfiguredSigningAlgorithm()
+	if err != nil {
+		return issuer.RotationResult{}, err
+	}
+
+	keyIDStrategy, err := issuer.ConfiguredKeyIDStrategy()
+	if err != nil {
+		return issuer.RotationResult{}, err
+	}
+
+	policy, err := issuer.ConfiguredRotationPolicy()
+	if err != nil {
+		return issuer.RotationResult{}, err
+	}
+
+	rotator := issuer.KeyRotator{
+		SSM:           SSM,
+		KMS:           KMS,
+		Custodian:     issuer.ConfiguredKeyCustodian(),
+		Algorithm:     alg,
+		KeyIDStrategy: keyIDStrategy,
+		Policy:        policy,
+	}
+
+	result, err := rotator.Rotate(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	slog.Info("key_rotator.handler/result", "created", result.Created, "promoted", result.Promoted, "retired", result.Retired)
+	return result, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hotsock/jwt-issuer/internal/issuer"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_handler(t *testing.T) {
+	os.Setenv(issuer.StackArnEnvVar, "arn:aws:cloudformation:us-east-1:111111111111:stack/JWTIssuer/d9385410-50ee-11ee-b05b-0a236ebfa8d3")
+
+	alg, err := issuer.LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	publicKeyPEM, err := issuer.EncodePublicKeyPEM(privateKey.Public())
+	require.NoError(t, err)
+
+	isParameter := func(name string) any {
+		return mock.MatchedBy(func(input *ssm.GetParameterInput) bool {
+			return lo.FromPtr(input.Name) == name
+		})
+	}
+
+	mockSSM := mocks.SSMAPI{}
+	mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.KeyringParameterName())).Return(nil, &ssmtypes.ParameterNotFound{})
+	mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.PublicKeyParameterName())).Return(&ssm.GetParameterOutput{
+		Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(string(publicKeyPEM))},
+	}, nil)
+	mockSSM.On("GetParameter", mock.Anything, isParameter(issuer.JWKSParameterName())).Return(nil, &ssmtypes.ParameterNotFound{})
+	mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(nil, nil)
+	SSM = &mockSSM
+	KMS = &mocks.KMSAPI{}
+
+	t.Run("the first run records the stack key in a new keyring", func(t *testing.T) {
+		result, err := handler(context.Background(), events.EventBridgeEvent{DetailType: "Scheduled Event"})
+		require.NoError(t, err)
+		assert.Equal(t, issuer.RotationResult{}, result)
+
+		mockSSM.AssertNumberOfCalls(t, "PutParameter", 2)
+		keyringCall := mockSSM.Calls[2].Arguments[1].(*ssm.PutParameterInput)
+		assert.Equal(t, issuer.KeyringParameterName(), lo.FromPtr(keyringCall.Name))
+
+		var keyring issuer.KeyringConfig
+		require.NoError(t, json.Unmarshal([]byte(lo.FromPtr(keyringCall.Value)), &keyring))
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", keyring.ActiveKeyID)
+		require.Len(t, keyring.Keys, 1)
+		assert.Equal(t, issuer.CustodianParameterStore, keyring.Keys[0].Custodian)
+	})
+
+	t.Run("invalid rotation settings fail", func(t *testing.T) {
+		t.Setenv(issuer.KeyRotationIntervalDaysEnvVar, "soon")
+		_, err := handler(context.Background(), events.EventBridgeEvent{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,219 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"os"
+
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const SigningAlgorithmEnvVar = "SIGNING_ALGORITHM"
+const RSAKeySpecEnvVar = "RSA_KEY_SPEC"
+
+// DefaultSigningAlgorithm is used when no signing algorithm is configured.
+const DefaultSigningAlgorithm = "ES256"
+
+// SigningAlgorithm describes a JWS algorithm supported by the issuer and how it
+// maps to key generation and KMS.
+type SigningAlgorithm struct {
+	Method jwt.SigningMethod
+
+	// The JWK key type ("kty") of keys used with this algorithm: "EC", "RSA" or
+	// "OKP".
+	KeyType string
+
+	// The curve for ECDSA algorithms, nil otherwise.
+	Curve elliptic.Curve
+
+	// The KMS key spec for keys used with this algorithm, empty if KMS does not
+	// support the algorithm. For RSA algorithms this also determines the size
+	// of generated keys.
+	KMSKeySpec          kmstypes.KeySpec
+	KMSSigningAlgorithm kmstypes.SigningAlgorithmSpec
+}
+
+const (
+	KeyTypeEC  = "EC"
+	KeyTypeRSA = "RSA"
+	KeyTypeOKP = "OKP"
+)
+
+var rsaKeySpecBits = map[kmstypes.KeySpec]int{
+	kmstypes.KeySpecRsa2048: 2048,
+	kmstypes.KeySpecRsa3072: 3072,
+	kmstypes.KeySpecRsa4096: 4096,
+}
+
+var signingAlgorithms = map[string]SigningAlgorithm{
+	"ES256": {
+		Method:              jwt.SigningMethodES256,
+		KeyType:             KeyTypeEC,
+		Curve:               elliptic.P256(),
+		KMSKeySpec:          kmstypes.KeySpecEccNistP256,
+		KMSSigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha256,
+	},
+	"ES384": {
+		Method:              jwt.SigningMethodES384,
+		KeyType:             KeyTypeEC,
+		Curve:               elliptic.P384(),
+		KMSKeySpec:          kmstypes.KeySpecEccNistP384,
+		KMSSigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha384,
+	},
+	"ES512": {
+		Method:              jwt.SigningMethodES512,
+		KeyType:             KeyTypeEC,
+		Curve:               elliptic.P521(),
+		KMSKeySpec:          kmstypes.KeySpecEccNistP521,
+		KMSSigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha512,
+	},
+	"RS256": {
+		Method:              jwt.SigningMethodRS256,
+		KeyType:             KeyTypeRSA,
+		KMSKeySpec:          kmstypes.KeySpecRsa2048,
+		KMSSigningAlgorithm: kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256,
+	},
+	"PS256": {
+		Method:              jwt.SigningMethodPS256,
+		KeyType:             KeyTypeRSA,
+		KMSKeySpec:          kmstypes.KeySpecRsa2048,
+		KMSSigningAlgorithm: kmstypes.SigningAlgorithmSpecRsassaPssSha256,
+	},
+	"EdDSA": {
+		Method:  jwt.SigningMethodEdDSA,
+		KeyType: KeyTypeOKP,
+	},
+}
+
+// LookupSigningAlgorithm returns the signing algorithm for a JWS "alg" name.
+// An empty name returns the default algorithm.
+func LookupSigningAlgorithm(name string) (SigningAlgorithm, error) {
+	if name == "" {
+		name = DefaultSigningAlgorithm
+	}
+
+	alg, ok := signingAlgorithms[name]
+	if !ok {
+		return SigningAlgorithm{}, fmt.Errorf("issuer: unsupported signing algorithm %q", name)
+	}
+
+	return alg, nil
+}
+
+// ConfiguredSigningAlgorithm returns the signing algorithm configured for the
+// stack, including the RSA key spec for RSA algorithms.
+func ConfiguredSigningAlgorithm() (SigningAlgorithm, error) {
+	alg, err := LookupSigningAlgorithm(os.Getenv(SigningAlgorithmEnvVar))
+	if err != nil {
+		return SigningAlgorithm{}, err
+	}
+
+	if keySpec := os.Getenv(RSAKeySpecEnvVar); keySpec != "" && alg.IsRSA() {
+		return alg.WithRSAKeySpec(kmstypes.KeySpec(keySpec))
+	}
+
+	return alg, nil
+}
+
+func (a SigningAlgorithm) Name() string {
+	return a.Method.Alg()
+}
+
+// IsRSA reports whether the algorithm signs with RSA keys.
+func (a SigningAlgorithm) IsRSA() bool {
+	return a.KeyType == KeyTypeRSA
+}
+
+// IsECDSA reports whether the algorithm signs with ECDSA keys.
+func (a SigningAlgorithm) IsECDSA() bool {
+	return a.KeyType == KeyTypeEC
+}
+
+// SupportsKMS reports whether keys for the algorithm can be held in KMS.
+func (a SigningAlgorithm) SupportsKMS() bool {
+	return a.KMSSigningAlgorithm != ""
+}
+
+// WithRSAKeySpec returns a copy of the RSA algorithm that generates keys of
+// the given KMS key spec.
+func (a SigningAlgorithm) WithRSAKeySpec(keySpec kmstypes.KeySpec) (SigningAlgorithm, error) {
+	if !a.IsRSA() {
+		return SigningAlgorithm{}, fmt.Errorf("issuer: %s does not use RSA keys", a.Name())
+	}
+	if _, ok := rsaKeySpecBits[keySpec]; !ok {
+		return SigningAlgorithm{}, fmt.Errorf("issuer: unsupported RSA key spec %q", keySpec)
+	}
+
+	a.KMSKeySpec = keySpec
+	return a, nil
+}
+
+// Hash returns the hash function used by the algorithm.
+func (a SigningAlgorithm) Hash() crypto.Hash {
+	switch method := a.Method.(type) {
+	case *jwt.SigningMethodECDSA:
+		return method.Hash
+	case *jwt.SigningMethodRSA:
+		return method.Hash
+	case *jwt.SigningMethodRSAPSS:
+		return method.Hash
+	}
+	return 0
+}
+
+// GenerateKey generates a new private key suitable for this algorithm.
+func (a SigningAlgorithm) GenerateKey() (crypto.Signer, error) {
+	switch a.KeyType {
+	case KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, rsaKeySpecBits[a.KMSKeySpec])
+	case KeyTypeOKP:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	}
+
+	return ecdsa.GenerateKey(a.Curve, rand.Reader)
+}
+
+// CheckPublicKey returns an error if the public key cannot verify signatures
+// made with this algorithm.
+func (a SigningAlgorithm) CheckPublicKey(publicKey crypto.PublicKey) error {
+	if a.KeyType == KeyTypeOKP {
+		if _, ok := publicKey.(ed25519.PublicKey); !ok {
+			return fmt.Errorf("issuer: %s requires an Ed25519 key, got %T", a.Name(), publicKey)
+		}
+		return nil
+	}
+
+	if a.IsRSA() {
+		rsaKey, ok := publicKey.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("issuer: %s requires an RSA key, got %T", a.Name(), publicKey)
+		}
+		if rsaKey.N.BitLen() < 2048 {
+			return fmt.Errorf("issuer: %s requires an RSA key of at least 2048 bits, got %d", a.Name(), rsaKey.N.BitLen())
+		}
+		return nil
+	}
+
+	ecKey, ok := publicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("issuer: %s requires an ECDSA key, got %T", a.Name(), publicKey)
+	}
+	if ecKey.Curve != a.Curve {
+		return fmt.Errorf("issuer: %s requires curve %s, got %s", a.Name(), a.Curve.Params().Name, ecKey.Curve.Params().Name)
+	}
+
+	return nil
+}
+
+// signatureSize returns the size in bytes of each of the r and s integers in
+// an ECDSA signature for this algorithm.
+func (a SigningAlgorithm) signatureSize() int {
+	return (a.Curve.Params().BitSize + 7) / 8
+}
@@ -0,0 +1,100 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+)
+
+const BatchConcurrencyEnvVar = "BATCH_CONCURRENCY"
+
+// MaxBatchSize is the most inputs a batch can have. Larger batches risk
+// exceeding Lambda's 6 MB response limit and the issuer functions' 30 second
+// timeout.
+const MaxBatchSize = 1000
+
+// JWTIssuerBatchResult is the result for one input of a batch. Exactly one of
+// the output and Error is set.
+type JWTIssuerBatchResult struct {
+	*JWTIssuerFunctionOutput
+
+	// Why a token couldn't be issued for the input, in the same form as a
+	// failed invocation.
+	Error *messages.InvokeResponse_Error `json:"error,omitempty"`
+}
+
+// ConfiguredBatchConcurrency returns how many tokens in a batch may be signed
+// at the same time, defaulting to defaultConcurrency.
+func ConfiguredBatchConcurrency(defaultConcurrency int) (int, error) {
+	concurrency, err := intEnvVar(BatchConcurrencyEnvVar, defaultConcurrency)
+	if err != nil {
+		return 0, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return concurrency, nil
+}
+
+// IssueJWTBatch issues a token for each input, signing up to concurrency
+// tokens at a time. A failure for one input doesn't affect the others, and is
+// returned as that input's result error. Results are in the order of the
+// inputs.
+func IssueJWTBatch(ctx context.Context, keyring *Keyring, policy TokenPolicy, inputs []JWTIssuerFunctionInput, concurrency int) []JWTIssuerBatchResult {
+	results := make([]JWTIssuerBatchResult, len(inputs))
+	semaphore := make(chan struct{}, max(concurrency, 1))
+
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			output, err := IssueJWT(ctx, keyring, policy, input)
+			if err != nil {
+				issuerErr := ClassifyError(err)
+				results[i].Error = &messages.InvokeResponse_Error{Type: string(issuerErr.Code), Message: issuerErr.Message}
+				return
+			}
+			results[i].JWTIssuerFunctionOutput = &output
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// SingleOrBatchHandler returns a Lambda handler that calls single for a JSON
+// object payload, and batch for a JSON array of inputs.
+func SingleOrBatchHandler(
+	single func(context.Context, JWTIssuerFunctionInput) (JWTIssuerFunctionOutput, error),
+	batch func(context.Context, []JWTIssuerFunctionInput) ([]JWTIssuerBatchResult, error),
+) func(context.Context, json.RawMessage) (any, error) {
+	return func(ctx context.Context, payload json.RawMessage) (any, error) {
+		if bytes.HasPrefix(bytes.TrimSpace(payload), []byte("[")) {
+			var inputs []JWTIssuerFunctionInput
+			if err := json.Unmarshal(payload, &inputs); err != nil {
+				return nil, InvokeError(NewError(ErrorCodeInvalidInput, "issuer: invalid batch: %w", err))
+			}
+			if len(inputs) > MaxBatchSize {
+				return nil, InvokeError(NewError(ErrorCodeInvalidInput, "issuer: batch of %d inputs exceeds the maximum of %d", len(inputs), MaxBatchSize))
+			}
+
+			return batch(ctx, inputs)
+		}
+
+		var input JWTIssuerFunctionInput
+		if err := json.Unmarshal(payload, &input); err != nil {
+			return nil, InvokeError(NewError(ErrorCodeInvalidInput, "issuer: invalid input: %w", err))
+		}
+
+		return single(ctx, input)
+	}
+}
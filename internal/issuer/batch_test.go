@@ -0,0 +1,134 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencySigner records the most Sign calls in flight at once.
+type concurrencySigner struct {
+	Signer
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (s *concurrencySigner) Sign(ctx context.Context, signingString string) ([]byte, error) {
+	inFlight := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		current := s.maxInFlight.Load()
+		if inFlight <= current || s.maxInFlight.CompareAndSwap(current, inFlight) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	return s.Signer.Sign(ctx, signingString)
+}
+
+func Test_IssueJWTBatch(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	localSigner, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+	signer := &concurrencySigner{Signer: localSigner}
+	keyring, err := NewKeyring("", nil, signer)
+	require.NoError(t, err)
+
+	inputs := make([]JWTIssuerFunctionInput, 20)
+	for i := range inputs {
+		inputs[i] = JWTIssuerFunctionInput{Claims: jwt.MapClaims{"sub": fmt.Sprintf("user-%d", i)}}
+	}
+	inputs[3].KeyID = lo.ToPtr("missing")
+	inputs[7].TTL = lo.ToPtr(Duration("forever"))
+
+	results := IssueJWTBatch(context.Background(), keyring, TokenPolicy{}, inputs, 4)
+	require.Len(t, results, len(inputs))
+	assert.LessOrEqual(t, signer.maxInFlight.Load(), int32(4))
+	assert.Greater(t, signer.maxInFlight.Load(), int32(1))
+
+	for i, result := range results {
+		switch i {
+		case 3:
+			assert.Nil(t, result.JWTIssuerFunctionOutput)
+			assert.Equal(t, &messages.InvokeResponse_Error{Type: "KEY_NOT_FOUND", Message: `issuer: signing key not found: unknown kid "missing"`}, result.Error)
+		case 7:
+			assert.Nil(t, result.JWTIssuerFunctionOutput)
+			assert.Equal(t, "INVALID_INPUT", result.Error.Type)
+		default:
+			require.Nil(t, result.Error)
+			token, err := jwt.Parse(result.Token, func(*jwt.Token) (any, error) {
+				return privateKey.Public(), nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("user-%d", i), token.Claims.(jwt.MapClaims)["sub"])
+		}
+	}
+
+	encoded, err := json.Marshal(results[2:4])
+	require.NoError(t, err)
+	assert.JSONEq(t, fmt.Sprintf(`[{"token":%q},{"error":{"errorType":"KEY_NOT_FOUND","errorMessage":"issuer: signing key not found: unknown kid \"missing\""}}]`, results[2].Token), string(encoded))
+}
+
+func Test_SingleOrBatchHandler(t *testing.T) {
+	single := func(_ context.Context, input JWTIssuerFunctionInput) (JWTIssuerFunctionOutput, error) {
+		return JWTIssuerFunctionOutput{Token: "single:" + lo.FromPtr(input.KeyID)}, nil
+	}
+	batch := func(_ context.Context, inputs []JWTIssuerFunctionInput) ([]JWTIssuerBatchResult, error) {
+		results := make([]JWTIssuerBatchResult, len(inputs))
+		for i, input := range inputs {
+			results[i].JWTIssuerFunctionOutput = &JWTIssuerFunctionOutput{Token: "batch:" + lo.FromPtr(input.KeyID)}
+		}
+		return results, nil
+	}
+	handler := SingleOrBatchHandler(single, batch)
+
+	output, err := handler(context.Background(), json.RawMessage(`{"kid":"a"}`))
+	require.NoError(t, err)
+	assert.Equal(t, JWTIssuerFunctionOutput{Token: "single:a"}, output)
+
+	output, err = handler(context.Background(), json.RawMessage(` [{"kid":"a"},{"kid":"b"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []JWTIssuerBatchResult{
+		{JWTIssuerFunctionOutput: &JWTIssuerFunctionOutput{Token: "batch:a"}},
+		{JWTIssuerFunctionOutput: &JWTIssuerFunctionOutput{Token: "batch:b"}},
+	}, output)
+
+	_, err = handler(context.Background(), json.RawMessage(`[{"claims":"nope"}]`))
+	var invokeErr messages.InvokeResponse_Error
+	require.ErrorAs(t, err, &invokeErr)
+	assert.Equal(t, "INVALID_INPUT", invokeErr.Type)
+
+	_, err = handler(context.Background(), json.RawMessage(fmt.Sprintf("[%s{}]", strings.Repeat("{},", MaxBatchSize))))
+	require.ErrorAs(t, err, &invokeErr)
+	assert.Equal(t, messages.InvokeResponse_Error{Type: "INVALID_INPUT", Message: "issuer: batch of 1001 inputs exceeds the maximum of 1000"}, invokeErr)
+}
+
+func Test_ConfiguredBatchConcurrency(t *testing.T) {
+	concurrency, err := ConfiguredBatchConcurrency(10)
+	require.NoError(t, err)
+	assert.Equal(t, 10, concurrency)
+
+	t.Setenv(BatchConcurrencyEnvVar, "25")
+	concurrency, err = ConfiguredBatchConcurrency(10)
+	require.NoError(t, err)
+	assert.Equal(t, 25, concurrency)
+
+	t.Setenv(BatchConcurrencyEnvVar, "0")
+	concurrency, err = ConfiguredBatchConcurrency(10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, concurrency)
+}
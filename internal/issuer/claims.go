@@ -0,0 +1,133 @@
+package issuer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimError describes a claim that can't be used in a token.
+type ClaimError struct {
+	// The path to the claim in the input, such as "claims.aud[1]".
+	Path   string
+	Reason string
+}
+
+func (e *ClaimError) Error() string {
+	return fmt.Sprintf("issuer: invalid claim %s: %s", e.Path, e.Reason)
+}
+
+// ValidateRegisteredClaims checks that the RFC 7519 registered claims that are
+// present have the right types: "exp", "nbf" and "iat" are numbers, "aud" is
+// a string or an array of strings, and "iss", "sub" and "jti" are strings.
+// Other claims aren't checked.
+func ValidateRegisteredClaims(claims jwt.MapClaims) error {
+	for _, name := range []string{"exp", "nbf", "iat"} {
+		value, ok := claims[name]
+		if !ok || isNumericDateValue(value) {
+			continue
+		}
+		if jsonType(value) == "number" {
+			return &ClaimError{Path: "claims." + name, Reason: fmt.Sprintf("must be a number of seconds since the epoch between years 1 and 9999, got %v", value)}
+		}
+		return &ClaimError{Path: "claims." + name, Reason: fmt.Sprintf("must be a number of seconds since the epoch, got %s", jsonType(value))}
+	}
+
+	for _, name := range []string{"iss", "sub", "jti"} {
+		if value, ok := claims[name]; ok {
+			if _, isString := value.(string); !isString {
+				return &ClaimError{Path: "claims." + name, Reason: fmt.Sprintf("must be a string, got %s", jsonType(value))}
+			}
+		}
+	}
+
+	if value, ok := claims["aud"]; ok {
+		switch aud := value.(type) {
+		case string, []string:
+		case []any:
+			for i, item := range aud {
+				if _, isString := item.(string); !isString {
+					return &ClaimError{Path: fmt.Sprintf("claims.aud[%d]", i), Reason: fmt.Sprintf("must be a string, got %s", jsonType(item))}
+				}
+			}
+		default:
+			return &ClaimError{Path: "claims.aud", Reason: fmt.Sprintf("must be a string or an array of strings, got %s", jsonType(value))}
+		}
+	}
+
+	return nil
+}
+
+func isNumericDateValue(value any) bool {
+	_, ok := numericDateValue(value)
+	return ok
+}
+
+// The range of NumericDate values, from 0001-01-01T00:00:00Z to
+// 9999-12-31T23:59:59Z. Values outside it don't convert to a time that
+// compares correctly, so a huge exp could look like it's in the past.
+const (
+	minNumericDateSeconds = -62135596800
+	maxNumericDateSeconds = 253402300799
+)
+
+// numericDateValue converts a NumericDate claim value to a time. It returns
+// false for values that aren't numbers or are outside the range of years 1 to
+// 9999.
+func numericDateValue(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case *jwt.NumericDate:
+		if v == nil {
+			return time.Time{}, false
+		}
+		return v.Time, true
+	case float64:
+		return numericDateSeconds(v)
+	case float32:
+		return numericDateSeconds(float64(v))
+	case int:
+		return numericDateSeconds(float64(v))
+	case int32:
+		return numericDateSeconds(float64(v))
+	case int64:
+		return numericDateSeconds(float64(v))
+	case json.Number:
+		seconds, err := v.Float64()
+		if err != nil {
+			return time.Time{}, false
+		}
+		return numericDateSeconds(seconds)
+	}
+	return time.Time{}, false
+}
+
+func numericDateSeconds(seconds float64) (time.Time, bool) {
+	if math.IsNaN(seconds) || seconds < minNumericDateSeconds || seconds > maxNumericDateSeconds {
+		return time.Time{}, false
+	}
+
+	whole, fraction := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(fraction*1e9)), true
+}
+
+// jsonType names the JSON type of a decoded value for error messages.
+func jsonType(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, float32, int, int32, int64, json.Number:
+		return "number"
+	case []any, []string:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
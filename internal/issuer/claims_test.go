@@ -0,0 +1,61 @@
+package issuer
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ValidateRegisteredClaims(t *testing.T) {
+	t.Run("accepts valid claims", func(t *testing.T) {
+		var claims jwt.MapClaims
+		require.NoError(t, json.Unmarshal([]byte(`{
+			"exp": 1713836950,
+			"nbf": 1713836900.5,
+			"iat": 1713836900,
+			"aud": ["hotsock", "internal"],
+			"iss": "https://example.com",
+			"sub": "user-1",
+			"jti": "abc",
+			"channels": {"chat": {"subscribe": true}}
+		}`), &claims))
+		assert.NoError(t, ValidateRegisteredClaims(claims))
+
+		assert.NoError(t, ValidateRegisteredClaims(jwt.MapClaims{"aud": "hotsock", "exp": jwt.NewNumericDate(time.Now())}))
+		assert.NoError(t, ValidateRegisteredClaims(jwt.MapClaims{}))
+	})
+
+	for _, tc := range []struct {
+		claims string
+		path   string
+		reason string
+	}{
+		{`{"exp": "tomorrow"}`, "claims.exp", "must be a number of seconds since the epoch, got string"},
+		{`{"nbf": null}`, "claims.nbf", "must be a number of seconds since the epoch, got null"},
+		{`{"iat": true}`, "claims.iat", "must be a number of seconds since the epoch, got boolean"},
+		{`{"exp": 1e19}`, "claims.exp", "must be a number of seconds since the epoch between years 1 and 9999, got 1e+19"},
+		{`{"exp": 1e300}`, "claims.exp", "must be a number of seconds since the epoch between years 1 and 9999, got 1e+300"},
+		{`{"nbf": -1e19}`, "claims.nbf", "must be a number of seconds since the epoch between years 1 and 9999, got -1e+19"},
+		{`{"aud": 5}`, "claims.aud", "must be a string or an array of strings, got number"},
+		{`{"aud": ["hotsock", {"name": "internal"}]}`, "claims.aud[1]", "must be a string, got object"},
+		{`{"iss": ["a"]}`, "claims.iss", "must be a string, got array"},
+		{`{"sub": 123}`, "claims.sub", "must be a string, got number"},
+		{`{"jti": false}`, "claims.jti", "must be a string, got boolean"},
+	} {
+		t.Run(tc.claims, func(t *testing.T) {
+			var claims jwt.MapClaims
+			require.NoError(t, json.Unmarshal([]byte(tc.claims), &claims))
+
+			err := ValidateRegisteredClaims(claims)
+			var claimErr *ClaimError
+			require.True(t, errors.As(err, &claimErr))
+			assert.Equal(t, tc.path, claimErr.Path)
+			assert.Equal(t, tc.reason, claimErr.Reason)
+		})
+	}
+}
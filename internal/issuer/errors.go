@@ -0,0 +1,146 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrorCode is a stable identifier for a class of issuer failure. Issuer
+// functions return it as the Lambda "errorType" so callers can decide whether
+// to retry.
+type ErrorCode string
+
+const (
+	// The request is malformed. Retrying won't help.
+	ErrorCodeInvalidInput ErrorCode = "INVALID_INPUT"
+
+	// The request is well-formed but asks for a token the stack's policy
+	// doesn't allow. Retrying won't help.
+	ErrorCodeClaimPolicyViolation ErrorCode = "CLAIM_POLICY_VIOLATION"
+
+	// KMS or Parameter Store throttled the request. Retry with backoff.
+	ErrorCodeSignerThrottled ErrorCode = "SIGNER_THROTTLED"
+
+	// The signing key couldn't be loaded or used because KMS or Parameter
+	// Store failed, timed out or couldn't be reached. Retrying may help.
+	ErrorCodeSignerUnavailable ErrorCode = "SIGNER_UNAVAILABLE"
+
+	// The requested kid or profile isn't in the keyring, or its key isn't
+	// active. Retrying won't help.
+	ErrorCodeKeyNotFound ErrorCode = "KEY_NOT_FOUND"
+
+	// An unexpected failure in the issuer, including KMS or Parameter Store
+	// rejecting its requests, such as when access is denied or a key is
+	// missing or disabled. Retrying won't help until the stack is fixed.
+	ErrorCodeInternal ErrorCode = "INTERNAL_ERROR"
+)
+
+// Retryable reports whether a request that failed with the code may succeed
+// if it's retried unchanged.
+func (c ErrorCode) Retryable() bool {
+	return c == ErrorCodeSignerThrottled || c == ErrorCodeSignerUnavailable
+}
+
+// Error is an issuer failure with a stable code.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Err     error
+}
+
+// NewError returns an error with the code, described by the format.
+func NewError(code ErrorCode, format string, args ...any) *Error {
+	err := fmt.Errorf(format, args...)
+	return &Error{Code: code, Message: err.Error(), Err: errors.Unwrap(err)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError returns err as an *Error, choosing a code for errors that
+// don't have one.
+func ClassifyError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var issuerErr *Error
+	if errors.As(err, &issuerErr) && issuerErr == err {
+		return issuerErr
+	}
+
+	code := ErrorCodeInternal
+	var apiErr smithy.APIError
+	var unavailableErr *UnavailableError
+	switch {
+	case issuerErr != nil:
+		code = issuerErr.Code
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrKeyNotActive):
+		code = ErrorCodeKeyNotFound
+	case errors.As(err, &apiErr) && isThrottleErrorCode(apiErr.ErrorCode()):
+		code = ErrorCodeSignerThrottled
+	case errors.As(err, &unavailableErr), isServerOrNetworkError(err):
+		code = ErrorCodeSignerUnavailable
+	}
+
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+// InvokeError converts err to the error returned from a Lambda handler, so the
+// invocation's "errorType" is the error code and "errorMessage" describes it.
+func InvokeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	issuerErr := ClassifyError(err)
+
+	return messages.InvokeResponse_Error{
+		Type:    string(issuerErr.Code),
+		Message: issuerErr.Message,
+	}
+}
+
+// isServerOrNetworkError reports whether err is a server error from an AWS
+// service, a timeout, or a failure to reach the service, as opposed to the
+// service rejecting the request.
+func isServerOrNetworkError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorFault() == smithy.FaultServer {
+		return true
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) && statusErr.HTTPStatusCode() >= 500 {
+		return true
+	}
+
+	var sendErr *smithyhttp.RequestSendError
+	if errors.As(err, &sendErr) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func isThrottleErrorCode(code string) bool {
+	_, ok := retry.DefaultThrottleErrorCodes[code]
+	return ok
+}
@@ -0,0 +1,83 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code ErrorCode
+	}{
+		{NewError(ErrorCodeInvalidInput, "issuer: bad input"), ErrorCodeInvalidInput},
+		{fmt.Errorf("wrapped: %w", NewError(ErrorCodeClaimPolicyViolation, "issuer: ttl too long")), ErrorCodeClaimPolicyViolation},
+		{fmt.Errorf("%w: unknown kid %q", ErrKeyNotFound, "missing"), ErrorCodeKeyNotFound},
+		{fmt.Errorf("%w: kid %q is %s", ErrKeyNotActive, "old", KeyStateRetired), ErrorCodeKeyNotFound},
+		{&kmstypes.KMSInternalException{Message: new(string)}, ErrorCodeSignerUnavailable},
+		{&kmstypes.KeyUnavailableException{}, ErrorCodeSignerUnavailable},
+		{&UnavailableError{Attempts: 3, Err: errors.New("boom")}, ErrorCodeSignerUnavailable},
+		{&UnavailableError{Attempts: 3, Err: &kmstypes.LimitExceededException{}}, ErrorCodeSignerThrottled},
+		{fmt.Errorf("operation error KMS: Sign: %w", &kmstypes.LimitExceededException{}), ErrorCodeSignerThrottled},
+		{fmt.Errorf("operation error KMS: Sign: %w", context.DeadlineExceeded), ErrorCodeSignerUnavailable},
+		{&smithyhttp.RequestSendError{Err: errors.New("connection reset")}, ErrorCodeSignerUnavailable},
+		{serviceError(http.StatusServiceUnavailable, &smithy.GenericAPIError{Code: "ServiceUnavailable"}), ErrorCodeSignerUnavailable},
+		{&kmstypes.NotFoundException{}, ErrorCodeInternal},
+		{&kmstypes.DisabledException{}, ErrorCodeInternal},
+		{&kmstypes.KMSInvalidStateException{}, ErrorCodeInternal},
+		{&ssmtypes.ParameterNotFound{}, ErrorCodeInternal},
+		{serviceError(http.StatusBadRequest, &smithy.GenericAPIError{Code: "AccessDeniedException"}), ErrorCodeInternal},
+		{serviceError(http.StatusBadRequest, &smithy.GenericAPIError{Code: "ValidationException"}), ErrorCodeInternal},
+		{errors.New("boom"), ErrorCodeInternal},
+	} {
+		t.Run(tc.err.Error(), func(t *testing.T) {
+			issuerErr := ClassifyError(tc.err)
+			require.NotNil(t, issuerErr)
+			assert.Equal(t, tc.code, issuerErr.Code)
+			assert.Equal(t, tc.err.Error(), issuerErr.Message)
+		})
+	}
+
+	assert.Nil(t, ClassifyError(nil))
+}
+
+// serviceError wraps err the way the SDK does for an error response with the
+// status code.
+func serviceError(statusCode int, err error) error {
+	return &smithy.OperationError{
+		ServiceID:     "KMS",
+		OperationName: "Sign",
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{Response: &smithyhttp.Response{Response: &http.Response{StatusCode: statusCode}}, Err: err},
+		},
+	}
+}
+
+func Test_InvokeError(t *testing.T) {
+	assert.NoError(t, InvokeError(nil))
+
+	err := InvokeError(fmt.Errorf("%w: unknown kid %q", ErrKeyNotFound, "missing"))
+	assert.Equal(t, messages.InvokeResponse_Error{
+		Type:    "KEY_NOT_FOUND",
+		Message: `issuer: signing key not found: unknown kid "missing"`,
+	}, err)
+}
+
+func Test_ErrorCode_Retryable(t *testing.T) {
+	assert.True(t, ErrorCodeSignerThrottled.Retryable())
+	assert.True(t, ErrorCodeSignerUnavailable.Retryable())
+	assert.False(t, ErrorCodeInvalidInput.Retryable())
+	assert.False(t, ErrorCodeKeyNotFound.Retryable())
+}
@@ -0,0 +1,134 @@
+package issuer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JTIFormatEnvVar = "JTI_FORMAT"
+	JTIPrefixEnvVar = "JTI_PREFIX"
+)
+
+// IDGenerator generates unique IDs for "jti" claims.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// JTIFormat selects the IDGenerator used for "jti" claims.
+type JTIFormat string
+
+const (
+	// JTIFormatUUIDv4 generates random RFC 9562 version 4 UUIDs.
+	JTIFormatUUIDv4 JTIFormat = "UUIDv4"
+
+	// JTIFormatUUIDv7 generates RFC 9562 version 7 UUIDs, which sort by the
+	// millisecond they were generated in.
+	JTIFormatUUIDv7 JTIFormat = "UUIDv7"
+
+	// JTIFormatULID generates ULIDs, which sort by the millisecond they were
+	// generated in and are shorter than UUIDs.
+	JTIFormatULID JTIFormat = "ULID"
+)
+
+// ConfiguredJTIFormat returns the jti format configured for the stack,
+// defaulting to JTIFormatUUIDv4.
+func ConfiguredJTIFormat() (JTIFormat, error) {
+	format := JTIFormat(os.Getenv(JTIFormatEnvVar))
+	if format == "" {
+		return JTIFormatUUIDv4, nil
+	}
+
+	if _, err := format.Generator(); err != nil {
+		return "", err
+	}
+
+	return format, nil
+}
+
+// Generator returns the IDGenerator for the format. The empty format is
+// JTIFormatUUIDv4.
+func (f JTIFormat) Generator() (IDGenerator, error) {
+	switch f {
+	case "", JTIFormatUUIDv4:
+		return UUIDv4Generator{}, nil
+	case JTIFormatUUIDv7:
+		return UUIDv7Generator{}, nil
+	case JTIFormatULID:
+		return ULIDGenerator{}, nil
+	}
+
+	return nil, fmt.Errorf("issuer: unsupported jti format %q", f)
+}
+
+// UUIDv4Generator generates random UUIDs.
+type UUIDv4Generator struct{}
+
+func (UUIDv4Generator) NewID() (string, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// UUIDv7Generator generates time-ordered UUIDs.
+type UUIDv7Generator struct{}
+
+func (UUIDv7Generator) NewID() (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	return id.String(), nil
+}
+
+// ULIDGenerator generates ULIDs: a 48-bit millisecond timestamp followed by 80
+// random bits, encoded as 26 characters of Crockford's base32. IDs generated
+// in the same millisecond aren't ordered relative to each other.
+type ULIDGenerator struct{}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (ULIDGenerator) NewID() (string, error) {
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(time.Now().UnixMilli())<<16)
+	if _, err := rand.Read(id[6:]); err != nil {
+		return "", err
+	}
+
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	var encoded [26]byte
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(encoded[:]), nil
+}
+
+// PrefixedIDGenerator prepends a static prefix, such as "hs_", to the IDs from
+// another generator so they're recognizable in logs.
+type PrefixedIDGenerator struct {
+	Prefix    string
+	Generator IDGenerator
+}
+
+func (g PrefixedIDGenerator) NewID() (string, error) {
+	id, err := g.Generator.NewID()
+	if err != nil {
+		return "", err
+	}
+
+	return g.Prefix + id, nil
+}
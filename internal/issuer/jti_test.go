@@ -0,0 +1,84 @@
+package issuer
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_JTIFormat_Generator(t *testing.T) {
+	t.Run("UUIDv4", func(t *testing.T) {
+		generator, err := JTIFormatUUIDv4.Generator()
+		require.NoError(t, err)
+		id, err := generator.NewID()
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(4), uuid.MustParse(id).Version())
+	})
+
+	t.Run("UUIDv7", func(t *testing.T) {
+		generator, err := JTIFormatUUIDv7.Generator()
+		require.NoError(t, err)
+		id, err := generator.NewID()
+		require.NoError(t, err)
+		parsed := uuid.MustParse(id)
+		assert.Equal(t, uuid.Version(7), parsed.Version())
+		assert.WithinDuration(t, time.Now(), time.Unix(parsed.Time().UnixTime()), 2*time.Second)
+	})
+
+	t.Run("ULID", func(t *testing.T) {
+		generator, err := JTIFormatULID.Generator()
+		require.NoError(t, err)
+
+		before := time.Now().UnixMilli()
+		id, err := generator.NewID()
+		require.NoError(t, err)
+		assert.Regexp(t, `^[0-7][0-9A-HJKMNP-TV-Z]{25}$`, id)
+
+		var ms int64
+		for _, c := range id[:10] {
+			ms = ms<<5 | int64(strings.IndexRune(crockfordBase32, c))
+		}
+		assert.GreaterOrEqual(t, ms, before)
+		assert.LessOrEqual(t, ms, time.Now().UnixMilli())
+
+		next, err := generator.NewID()
+		require.NoError(t, err)
+		assert.NotEqual(t, id, next)
+	})
+
+	t.Run("defaults to UUIDv4", func(t *testing.T) {
+		generator, err := JTIFormat("").Generator()
+		require.NoError(t, err)
+		assert.Equal(t, UUIDv4Generator{}, generator)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := JTIFormat("uuidv7").Generator()
+		assert.EqualError(t, err, `issuer: unsupported jti format "uuidv7"`)
+	})
+}
+
+func Test_PrefixedIDGenerator(t *testing.T) {
+	id, err := PrefixedIDGenerator{Prefix: "hs_", Generator: ULIDGenerator{}}.NewID()
+	require.NoError(t, err)
+	assert.Regexp(t, `^hs_[0-9A-HJKMNP-TV-Z]{26}$`, id)
+}
+
+func Test_ConfiguredJTIFormat(t *testing.T) {
+	format, err := ConfiguredJTIFormat()
+	require.NoError(t, err)
+	assert.Equal(t, JTIFormatUUIDv4, format)
+
+	t.Setenv(JTIFormatEnvVar, "UUIDv7")
+	format, err = ConfiguredJTIFormat()
+	require.NoError(t, err)
+	assert.Equal(t, JTIFormatUUIDv7, format)
+
+	t.Setenv(JTIFormatEnvVar, "snowflake")
+	_, err = ConfiguredJTIFormat()
+	assert.EqualError(t, err, `issuer: unsupported jti format "snowflake"`)
+}
@@ -0,0 +1,90 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/samber/lo"
+)
+
+// JWK is a public JSON Web Key as defined by RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set as defined by RFC 7517 Section 5.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK converts a supported public key (ECDSA, RSA or Ed25519) to a JWK for
+// signature verification.
+func NewJWK(publicKey crypto.PublicKey, keyID string, alg string) (JWK, error) {
+	jwk := JWK{
+		KeyID:     keyID,
+		Algorithm: alg,
+		Use:       "sig",
+	}
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.KeyType = KeyTypeEC
+		jwk.Curve = key.Curve.Params().Name
+		jwk.X = encodeJWKInt(key.X, size)
+		jwk.Y = encodeJWKInt(key.Y, size)
+	case *rsa.PublicKey:
+		jwk.KeyType = KeyTypeRSA
+		jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = KeyTypeOKP
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(key)
+	default:
+		return JWK{}, fmt.Errorf("issuer: unsupported public key type %T", publicKey)
+	}
+
+	return jwk, nil
+}
+
+// PutJWKSParameter writes the JWK Set to its Parameter Store parameter,
+// replacing any existing value.
+func PutJWKSParameter(ctx context.Context, ssmClient SSMAPI, set JWKSet) error {
+	value, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	_, err = ssmClient.PutParameter(ctx, &ssm.PutParameterInput{
+		DataType:    lo.ToPtr("text"),
+		Description: lo.ToPtr("JWT Issuer JSON Web Key Set"),
+		Name:        lo.ToPtr(JWKSParameterName()),
+		Overwrite:   lo.ToPtr(true),
+		Type:        ssmtypes.ParameterTypeString,
+		Value:       lo.ToPtr(string(value)),
+	})
+
+	return err
+}
+
+func encodeJWKInt(n *big.Int, size int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+}
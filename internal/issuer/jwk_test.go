@@ -0,0 +1,69 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewJWK(t *testing.T) {
+	t.Run("EC keys use fixed-width coordinates", func(t *testing.T) {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		require.NoError(t, err)
+
+		jwk, err := NewJWK(&privateKey.PublicKey, "kid-1", "ES512")
+		require.NoError(t, err)
+
+		assert.Equal(t, "EC", jwk.KeyType)
+		assert.Equal(t, "P-521", jwk.Curve)
+		assert.Equal(t, "kid-1", jwk.KeyID)
+		assert.Equal(t, "ES512", jwk.Algorithm)
+		assert.Equal(t, "sig", jwk.Use)
+
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		require.NoError(t, err)
+		assert.Len(t, x, 66)
+		assert.Equal(t, 0, new(big.Int).SetBytes(x).Cmp(privateKey.X))
+	})
+
+	t.Run("RSA keys", func(t *testing.T) {
+		privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		jwk, err := NewJWK(&privateKey.PublicKey, "kid-2", "RS256")
+		require.NoError(t, err)
+
+		assert.Equal(t, "RSA", jwk.KeyType)
+		assert.Equal(t, "AQAB", jwk.E)
+		assert.Empty(t, jwk.Curve)
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		require.NoError(t, err)
+		assert.Equal(t, 0, new(big.Int).SetBytes(n).Cmp(privateKey.N))
+	})
+
+	t.Run("Ed25519 keys", func(t *testing.T) {
+		publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		jwk, err := NewJWK(publicKey, "kid-3", "EdDSA")
+		require.NoError(t, err)
+
+		assert.Equal(t, "OKP", jwk.KeyType)
+		assert.Equal(t, "Ed25519", jwk.Curve)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(publicKey), jwk.X)
+	})
+
+	t.Run("unsupported keys", func(t *testing.T) {
+		_, err := NewJWK("not a key", "kid-4", "ES256")
+		require.Error(t, err)
+	})
+}
@@ -1,11 +1,14 @@
 package issuer
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"maps"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/google/uuid"
 	"github.com/samber/lo"
 )
 
@@ -14,47 +17,410 @@ type JWTIssuerFunctionInput struct {
 	// Overrides "iat" in Claims, if true.
 	SetIat *bool `json:"setIat,omitempty"`
 
-	// Whether or not to generate an apply a `jti` claim with a generated UUID.
-	// Overrides "jti" in Claims, if true.
+	// Whether or not to generate an apply a `jti` claim with a generated ID, a
+	// UUID unless the input or stack selects another format. Overrides "jti" in
+	// Claims, if true.
 	SetJti *bool `json:"setJti,omitempty"`
 
-	// Optional number of seconds until the token will expire. Overrides "exp" in
-	// Claims, if provided.
-	TTL *int64 `json:"ttl,omitempty"`
+	// Optional format of the generated "jti". Defaults to the stack's jti
+	// format. Implies SetJti.
+	JTIFormat *JTIFormat `json:"jtiFormat,omitempty"`
+
+	// Optional static prefix for the generated "jti". Defaults to the stack's
+	// jti prefix. Implies SetJti.
+	JTIPrefix *string `json:"jtiPrefix,omitempty"`
+
+	// Whether or not to apply a not before "nbf" claim with the time the token
+	// becomes valid. Overrides "nbf" in Claims, if true.
+	SetNbf *bool `json:"setNbf,omitempty"`
+
+	// Optional time the token becomes valid, as an RFC 3339 timestamp or a
+	// duration after issuance. Sets "nbf" in Claims, and TTL counts from this
+	// time instead of issuance.
+	NotBefore *Time `json:"notBefore,omitempty"`
+
+	// Optional duration to backdate "iat" and "nbf" by, so verifiers with
+	// clocks running slightly fast don't reject the token. Defaults to the
+	// stack's clock skew.
+	ClockSkew *Duration `json:"clockSkew,omitempty"`
+
+	// Optional duration until the token will expire, as a number of seconds or
+	// a duration string like "15m" or "PT1H". Overrides "exp" in Claims, if
+	// provided.
+	TTL *Duration `json:"ttl,omitempty"`
+
+	// Optional time the token will expire, as an RFC 3339 timestamp or a
+	// duration after issuance. Overrides "exp" in Claims, if provided. Can't be
+	// combined with TTL.
+	ExpiresAt *Time `json:"expiresAt,omitempty"`
 
 	// All the claims for the token.
 	Claims jwt.MapClaims `json:"claims,omitempty"`
+
+	// Optional "kid" of the key to sign with. Defaults to the active key.
+	KeyID *string `json:"kid,omitempty"`
+
+	// Optional name of a signing profile from the keyring, selecting the key to
+	// sign with. Can't be combined with KeyID.
+	Profile *string `json:"profile,omitempty"`
+
+	// Optional audiences to issue a token for each of, from the same claims.
+	// Each token's "aud" claim is its audience, overriding "aud" in Claims, and
+	// each gets its own generated "jti". The output's Tokens has the tokens,
+	// keyed by audience.
+	Audiences []string `json:"audiences,omitempty"`
+
+	// Whether or not to include the token's metadata and claims in the output,
+	// so callers don't need to decode the token.
+	IncludeMetadata *bool `json:"includeMetadata,omitempty"`
 }
 
 type JWTIssuerFunctionOutput struct {
-	// The signed JWT.
-	Token string `json:"token"`
+	// The signed JWT. Empty if the input has Audiences.
+	Token string `json:"token,omitempty"`
+
+	// The signed JWT for each audience, if the input has Audiences.
+	Tokens map[string]JWTIssuerFunctionOutput `json:"tokens,omitempty"`
+
+	// The remaining fields are only set if the input asks for metadata, and
+	// then only if the token has the claim or header they come from.
+
+	// The "exp" claim, in seconds since the epoch.
+	ExpiresAt *int64 `json:"expiresAt,omitempty"`
+
+	// The "iat" claim, in seconds since the epoch.
+	IssuedAt *int64 `json:"issuedAt,omitempty"`
+
+	// The "nbf" claim, in seconds since the epoch.
+	NotBefore *int64 `json:"notBefore,omitempty"`
+
+	// The "jti" claim.
+	JTI *string `json:"jti,omitempty"`
+
+	// The "kid" header.
+	KeyID *string `json:"kid,omitempty"`
+
+	// The "alg" header.
+	Algorithm *string `json:"alg,omitempty"`
+
+	// All the claims in the token, as signed.
+	Claims jwt.MapClaims `json:"claims,omitempty"`
+}
+
+const (
+	DefaultTTLEnvVar = "DEFAULT_TTL"
+	RequireExpEnvVar = "REQUIRE_EXP"
+	ClockSkewEnvVar  = "CLOCK_SKEW"
+)
+
+// TokenPolicy holds the stack's limits on issued tokens. The zero value
+// doesn't limit tokens.
+type TokenPolicy struct {
+	// The lifetime of tokens whose input has neither a TTL nor an "exp"
+	// claim. Zero leaves them without an "exp" claim.
+	DefaultTTL time.Duration
+
+	// The longest allowed lifetime, from when the token is issued to its "exp"
+	// claim, however "exp" is set. A later "nbf" doesn't extend it. Zero allows
+	// any lifetime.
+	MaxTTL time.Duration
+
+	// Whether tokens must have an "exp" claim. Always true when MaxTTL is set.
+	RequireExp bool
+
+	// How far "iat" and "nbf" are backdated when the input doesn't set its
+	// own clock skew.
+	ClockSkew time.Duration
+
+	// The format and prefix of generated "jti" claims when the input doesn't
+	// set its own.
+	JTIFormat JTIFormat
+	JTIPrefix string
+}
+
+// ConfiguredTokenPolicy returns the token policy configured for the stack.
+func ConfiguredTokenPolicy() (TokenPolicy, error) {
+	defaultTTL, err := intEnvVar(DefaultTTLEnvVar, 0)
+	if err != nil {
+		return TokenPolicy{}, err
+	}
+
+	maxTTL, err := intEnvVar(MaxTokenTTLEnvVar, 0)
+	if err != nil {
+		return TokenPolicy{}, err
+	}
+
+	clockSkew, err := intEnvVar(ClockSkewEnvVar, 0)
+	if err != nil {
+		return TokenPolicy{}, err
+	}
+
+	jtiFormat, err := ConfiguredJTIFormat()
+	if err != nil {
+		return TokenPolicy{}, err
+	}
+
+	policy := TokenPolicy{
+		DefaultTTL: time.Duration(defaultTTL) * time.Second,
+		MaxTTL:     time.Duration(maxTTL) * time.Second,
+		RequireExp: os.Getenv(RequireExpEnvVar) == "true",
+		ClockSkew:  time.Duration(clockSkew) * time.Second,
+		JTIFormat:  jtiFormat,
+		JTIPrefix:  os.Getenv(JTIPrefixEnvVar),
+	}
+
+	if policy.MaxTTL > 0 && policy.DefaultTTL > policy.MaxTTL {
+		return TokenPolicy{}, fmt.Errorf("issuer: %s of %d seconds exceeds %s of %d seconds", DefaultTTLEnvVar, defaultTTL, MaxTokenTTLEnvVar, maxTTL)
+	}
+
+	return policy, nil
+}
+
+// PrepareToken builds the unsigned token for the input, with the "alg" and
+// "kid" headers set for the signer that will sign it. It returns an
+// INVALID_INPUT error if the registered claims have the wrong types, and a
+// CLAIM_POLICY_VIOLATION error if the token's lifetime breaks the policy.
+func PrepareToken(input JWTIssuerFunctionInput, signer Signer, policy TokenPolicy) (*jwt.Token, error) {
+	return prepareToken(input, signer, policy, time.Now())
+}
+
+// PrepareAudienceTokens builds an unsigned token for each of the input's
+// audiences from the input's claims, keyed by audience. The tokens are
+// prepared as PrepareToken would, at the same time, so they differ only in
+// their "aud" and generated "jti" claims.
+func PrepareAudienceTokens(input JWTIssuerFunctionInput, signer Signer, policy TokenPolicy) (map[string]*jwt.Token, error) {
+	if len(input.Audiences) == 0 {
+		return nil, NewError(ErrorCodeInvalidInput, "issuer: audiences must not be empty")
+	}
+
+	now := time.Now()
+	tokens := make(map[string]*jwt.Token, len(input.Audiences))
+	for i, audience := range input.Audiences {
+		if audience == "" {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: audiences[%d] must not be empty", i)
+		}
+		if _, ok := tokens[audience]; ok {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: audiences[%d] %q is a duplicate", i, audience)
+		}
+
+		audienceInput := input
+		audienceInput.Claims = maps.Clone(input.Claims)
+		if audienceInput.Claims == nil {
+			audienceInput.Claims = jwt.MapClaims{}
+		}
+		audienceInput.Claims["aud"] = audience
+
+		token, err := prepareToken(audienceInput, signer, policy, now)
+		if err != nil {
+			return nil, err
+		}
+		tokens[audience] = token
+	}
+
+	return tokens, nil
 }
 
-func PrepareToken(input JWTIssuerFunctionInput, keyID string) *jwt.Token {
+func prepareToken(input JWTIssuerFunctionInput, signer Signer, policy TokenPolicy, now time.Time) (*jwt.Token, error) {
 	if input.Claims == nil {
 		input.Claims = jwt.MapClaims{}
 	}
 
-	now := time.Now()
+	if input.TTL != nil && input.ExpiresAt != nil {
+		return nil, NewError(ErrorCodeInvalidInput, "issuer: specify either ttl or expiresAt, not both")
+	}
+
+	clockSkew := policy.ClockSkew
+	if input.ClockSkew != nil {
+		var err error
+		if clockSkew, err = input.ClockSkew.Parse(); err != nil {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: invalid clockSkew: %w", err)
+		}
+		if clockSkew < 0 {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: clockSkew must not be negative, got %s", *input.ClockSkew)
+		}
+	}
+
+	validFrom := now
+	if input.NotBefore != nil {
+		var err error
+		if validFrom, err = input.NotBefore.Resolve(now); err != nil {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: invalid notBefore: %w", err)
+		}
+	}
+
 	if lo.FromPtr(input.SetIat) {
-		input.Claims["iat"] = jwt.NewNumericDate(now)
+		input.Claims["iat"] = jwt.NewNumericDate(now.Add(-clockSkew))
 	}
 
-	if input.TTL != nil {
-		input.Claims["exp"] = jwt.NewNumericDate(now.Add(time.Second * time.Duration(lo.FromPtr(input.TTL))))
+	if lo.FromPtr(input.SetNbf) || input.NotBefore != nil {
+		input.Claims["nbf"] = jwt.NewNumericDate(validFrom.Add(-clockSkew))
 	}
 
-	if lo.FromPtr(input.SetJti) {
-		input.Claims["jti"] = uuid.New().String()
+	switch {
+	case input.TTL != nil:
+		ttl, err := input.TTL.Parse()
+		if err != nil {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: invalid ttl: %w", err)
+		}
+		if ttl <= 0 {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: ttl must be positive, got %s", *input.TTL)
+		}
+		input.Claims["exp"] = jwt.NewNumericDate(validFrom.Add(ttl))
+	case input.ExpiresAt != nil:
+		expiresAt, err := input.ExpiresAt.Resolve(now)
+		if err != nil {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: invalid expiresAt: %w", err)
+		}
+		if !expiresAt.After(validFrom) {
+			return nil, NewError(ErrorCodeInvalidInput, "issuer: expiresAt of %s is not after the token becomes valid at %s", expiresAt.UTC().Format(time.RFC3339), validFrom.UTC().Format(time.RFC3339))
+		}
+		input.Claims["exp"] = jwt.NewNumericDate(expiresAt)
+	default:
+		if _, ok := input.Claims["exp"]; !ok && policy.DefaultTTL > 0 {
+			input.Claims["exp"] = jwt.NewNumericDate(validFrom.Add(policy.DefaultTTL))
+		}
+	}
+
+	if lo.FromPtr(input.SetJti) || input.JTIFormat != nil || input.JTIPrefix != nil {
+		generator, err := policy.jtiGenerator(input)
+		if err != nil {
+			return nil, NewError(ErrorCodeInvalidInput, "%w", err)
+		}
+		jti, err := generator.NewID()
+		if err != nil {
+			return nil, fmt.Errorf("issuer: generating jti: %w", err)
+		}
+		input.Claims["jti"] = jti
 	}
 
 	slog.Debug("issuer.PrepareToken/claims", "claims", input.Claims)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, input.Claims)
-	if keyID != "" {
+	if err := ValidateRegisteredClaims(input.Claims); err != nil {
+		return nil, NewError(ErrorCodeInvalidInput, "%w", err)
+	}
+
+	if err := policy.checkExpiration(input.Claims, now); err != nil {
+		return nil, err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(signer.Algorithm()), input.Claims)
+	if keyID := signer.KeyID(); keyID != "" {
 		token.Header["kid"] = keyID
 	}
 
-	return token
+	return token, nil
+}
+
+// IssueJWT prepares a token for the input and signs it with the key from the
+// keyring selected by the input. Both key custodians share this handler logic.
+// Handlers pass errors through InvokeError to return them with an error code.
+func IssueJWT(ctx context.Context, keyring *Keyring, policy TokenPolicy, input JWTIssuerFunctionInput) (JWTIssuerFunctionOutput, error) {
+	signer, err := keyring.Signer(lo.FromPtr(input.KeyID), lo.FromPtr(input.Profile))
+	if err != nil {
+		return JWTIssuerFunctionOutput{}, err
+	}
+
+	if input.Audiences != nil {
+		tokens, err := PrepareAudienceTokens(input, signer, policy)
+		if err != nil {
+			return JWTIssuerFunctionOutput{}, err
+		}
+
+		output := JWTIssuerFunctionOutput{Tokens: make(map[string]JWTIssuerFunctionOutput, len(tokens))}
+		for audience, token := range tokens {
+			if output.Tokens[audience], err = signToken(ctx, signer, token, lo.FromPtr(input.IncludeMetadata)); err != nil {
+				return JWTIssuerFunctionOutput{}, err
+			}
+		}
+
+		return output, nil
+	}
+
+	token, err := PrepareToken(input, signer, policy)
+	if err != nil {
+		return JWTIssuerFunctionOutput{}, err
+	}
+
+	return signToken(ctx, signer, token, lo.FromPtr(input.IncludeMetadata))
+}
+
+// signToken signs the token, returning the output for it.
+func signToken(ctx context.Context, signer Signer, token *jwt.Token, includeMetadata bool) (JWTIssuerFunctionOutput, error) {
+	signedToken, err := SignJWT(ctx, signer, token)
+	if err != nil {
+		return JWTIssuerFunctionOutput{}, err
+	}
+
+	output := JWTIssuerFunctionOutput{
+		Token: signedToken,
+	}
+
+	if includeMetadata {
+		output.addMetadata(token)
+	}
+
+	return output, nil
+}
+
+// addMetadata sets the output's metadata fields from the signed token.
+func (o *JWTIssuerFunctionOutput) addMetadata(token *jwt.Token) {
+	claims := token.Claims.(jwt.MapClaims)
+
+	o.ExpiresAt = numericDateSecondsPtr(claims["exp"])
+	o.IssuedAt = numericDateSecondsPtr(claims["iat"])
+	o.NotBefore = numericDateSecondsPtr(claims["nbf"])
+	if jti, ok := claims["jti"].(string); ok {
+		o.JTI = &jti
+	}
+	if kid, ok := token.Header["kid"].(string); ok {
+		o.KeyID = &kid
+	}
+	if alg, ok := token.Header["alg"].(string); ok {
+		o.Algorithm = &alg
+	}
+	o.Claims = claims
+}
+
+func numericDateSecondsPtr(value any) *int64 {
+	date, ok := numericDateValue(value)
+	if !ok {
+		return nil
+	}
+
+	return lo.ToPtr(date.Unix())
+}
+
+// jtiGenerator returns the generator for the input's "jti", using the input's
+// format and prefix over the policy's.
+func (p TokenPolicy) jtiGenerator(input JWTIssuerFunctionInput) (IDGenerator, error) {
+	generator, err := lo.FromPtrOr(input.JTIFormat, p.JTIFormat).Generator()
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix := lo.FromPtrOr(input.JTIPrefix, p.JTIPrefix); prefix != "" {
+		generator = PrefixedIDGenerator{Prefix: prefix, Generator: generator}
+	}
+
+	return generator, nil
+}
+
+// checkExpiration returns a CLAIM_POLICY_VIOLATION error if the claims don't
+// expire when the policy requires it, or expire later than it allows.
+func (p TokenPolicy) checkExpiration(claims jwt.MapClaims, now time.Time) error {
+	value, ok := claims["exp"]
+	if !ok {
+		if p.RequireExp || p.MaxTTL > 0 {
+			return NewError(ErrorCodeClaimPolicyViolation, "issuer: tokens must expire, set ttl or an exp claim")
+		}
+		return nil
+	}
+
+	// ValidateRegisteredClaims has already checked the type.
+	exp, _ := numericDateValue(value)
+	if p.MaxTTL > 0 && exp.After(now.Add(p.MaxTTL)) {
+		return NewError(ErrorCodeClaimPolicyViolation, "issuer: exp of %d is more than the maximum of %d seconds from now", exp.Unix(), int64(p.MaxTTL/time.Second))
+	}
+
+	return nil
 }
@@ -0,0 +1,483 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PrepareToken(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	t.Run("sets headers and generated claims", func(t *testing.T) {
+		token, err := PrepareToken(JWTIssuerFunctionInput{
+			Claims: jwt.MapClaims{"sub": "user-1"},
+			SetIat: lo.ToPtr(true),
+			SetJti: lo.ToPtr(true),
+			TTL:    lo.ToPtr(Duration("60")),
+		}, signer, TokenPolicy{})
+		require.NoError(t, err)
+
+		assert.Equal(t, "ES256", token.Header["alg"])
+		assert.Equal(t, "local-key", token.Header["kid"])
+
+		claims := token.Claims.(jwt.MapClaims)
+		assert.Equal(t, "user-1", claims["sub"])
+		assert.Len(t, claims["jti"], 36)
+		iat := claims["iat"].(*jwt.NumericDate)
+		exp := claims["exp"].(*jwt.NumericDate)
+		assert.WithinDuration(t, time.Now(), iat.Time, time.Second)
+		assert.Equal(t, 60*time.Second, exp.Sub(iat.Time))
+	})
+
+	t.Run("rejects registered claims with the wrong type", func(t *testing.T) {
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": "tomorrow"}}, signer, TokenPolicy{})
+
+		var issuerErr *Error
+		require.True(t, errors.As(err, &issuerErr))
+		assert.Equal(t, ErrorCodeInvalidInput, issuerErr.Code)
+		assert.Equal(t, "issuer: invalid claim claims.exp: must be a number of seconds since the epoch, got string", err.Error())
+
+		var claimErr *ClaimError
+		require.True(t, errors.As(err, &claimErr))
+		assert.Equal(t, "claims.exp", claimErr.Path)
+	})
+
+	t.Run("replaces invalid claims it generates", func(t *testing.T) {
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": "tomorrow"}, TTL: lo.ToPtr(Duration("60"))}, signer, TokenPolicy{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("applies the default TTL", func(t *testing.T) {
+		policy := TokenPolicy{DefaultTTL: time.Hour}
+
+		token, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}}, signer, policy)
+		require.NoError(t, err)
+		exp := token.Claims.(jwt.MapClaims)["exp"].(*jwt.NumericDate)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), exp.Time, time.Second)
+
+		// An explicit exp or ttl wins.
+		token, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": float64(1713836950)}}, signer, policy)
+		require.NoError(t, err)
+		assert.Equal(t, float64(1713836950), token.Claims.(jwt.MapClaims)["exp"])
+
+		token, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration("60"))}, signer, policy)
+		require.NoError(t, err)
+		exp = token.Claims.(jwt.MapClaims)["exp"].(*jwt.NumericDate)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), exp.Time, time.Second)
+	})
+
+	t.Run("enforces the maximum TTL", func(t *testing.T) {
+		policy := TokenPolicy{MaxTTL: time.Hour}
+
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration("3600"))}, signer, policy)
+		assert.NoError(t, err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration("3601"))}, signer, policy)
+		require.Error(t, err)
+		assert.Equal(t, ErrorCodeClaimPolicyViolation, ClassifyError(err).Code)
+		assert.Contains(t, err.Error(), "is more than the maximum of 3600 seconds from now")
+
+		// The lifetime counts from when the token is issued, so a later
+		// notBefore uses up part of it.
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("10m")), TTL: lo.ToPtr(Duration("50m"))}, signer, policy)
+		assert.NoError(t, err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("10m")), TTL: lo.ToPtr(Duration("3600"))}, signer, policy)
+		require.Error(t, err)
+		assert.Equal(t, ErrorCodeClaimPolicyViolation, ClassifyError(err).Code)
+		assert.Contains(t, err.Error(), "is more than the maximum of 3600 seconds from now")
+
+		farFuture := time.Now().Add(10 * 365 * 24 * time.Hour).Unix()
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": float64(farFuture)}}, signer, policy)
+		assertErrorCode(t, ErrorCodeClaimPolicyViolation, fmt.Sprintf("issuer: exp of %d is more than the maximum of 3600 seconds from now", farFuture), err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}}, signer, policy)
+		assertErrorCode(t, ErrorCodeClaimPolicyViolation, "issuer: tokens must expire, set ttl or an exp claim", err)
+
+		// Huge exp values must not overflow into the past and pass the check.
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": 1e19}}, signer, policy)
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: invalid claim claims.exp: must be a number of seconds since the epoch between years 1 and 9999, got 1e+19", err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": 1e300}}, signer, policy)
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: invalid claim claims.exp: must be a number of seconds since the epoch between years 1 and 9999, got 1e+300", err)
+	})
+
+	t.Run("requires exp", func(t *testing.T) {
+		policy := TokenPolicy{RequireExp: true}
+
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}}, signer, policy)
+		assertErrorCode(t, ErrorCodeClaimPolicyViolation, "issuer: tokens must expire, set ttl or an exp claim", err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": float64(1713836950)}}, signer, policy)
+		assert.NoError(t, err)
+	})
+}
+
+func Test_PrepareToken_notBefore(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	numericDate := func(t *testing.T, token *jwt.Token, claim string) time.Time {
+		t.Helper()
+		value, ok := token.Claims.(jwt.MapClaims)[claim].(*jwt.NumericDate)
+		require.True(t, ok, "%s is not set", claim)
+		return value.Time
+	}
+
+	t.Run("sets nbf to the issue time", func(t *testing.T) {
+		token, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, SetNbf: lo.ToPtr(true), SetIat: lo.ToPtr(true)}, signer, TokenPolicy{})
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), numericDate(t, token, "nbf"), time.Second)
+		assert.Equal(t, numericDate(t, token, "iat"), numericDate(t, token, "nbf"))
+	})
+
+	t.Run("issues tokens that become valid later", func(t *testing.T) {
+		token, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("1h")), TTL: lo.ToPtr(Duration("60"))}, signer, TokenPolicy{})
+		require.NoError(t, err)
+
+		nbf := numericDate(t, token, "nbf")
+		assert.WithinDuration(t, time.Now().Add(time.Hour), nbf, time.Second)
+		assert.Equal(t, time.Minute, numericDate(t, token, "exp").Sub(nbf))
+	})
+
+	t.Run("backdates iat and nbf by the clock skew", func(t *testing.T) {
+		policy := TokenPolicy{ClockSkew: 30 * time.Second}
+		input := JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, SetIat: lo.ToPtr(true), SetNbf: lo.ToPtr(true), TTL: lo.ToPtr(Duration("60"))}
+
+		token, err := PrepareToken(input, signer, policy)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(-30*time.Second), numericDate(t, token, "iat"), time.Second)
+		assert.WithinDuration(t, time.Now().Add(-30*time.Second), numericDate(t, token, "nbf"), time.Second)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), numericDate(t, token, "exp"), time.Second)
+
+		input = JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, SetIat: lo.ToPtr(true), ClockSkew: lo.ToPtr(Duration("5"))}
+		token, err = PrepareToken(input, signer, policy)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(-5*time.Second), numericDate(t, token, "iat"), time.Second)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ClockSkew: lo.ToPtr(Duration("-5"))}, signer, policy)
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: clockSkew must not be negative, got -5", err)
+	})
+
+	t.Run("accepts timestamps and duration strings", func(t *testing.T) {
+		notBefore := time.Now().Add(time.Hour).Truncate(time.Second)
+		input := JWTIssuerFunctionInput{
+			Claims:    jwt.MapClaims{},
+			NotBefore: lo.ToPtr(Time(notBefore.Format(time.RFC3339))),
+			TTL:       lo.ToPtr(Duration("PT15M")),
+			ClockSkew: lo.ToPtr(Duration("30s")),
+		}
+
+		token, err := PrepareToken(input, signer, TokenPolicy{})
+		require.NoError(t, err)
+		assert.True(t, notBefore.Add(-30*time.Second).Equal(numericDate(t, token, "nbf")))
+		assert.True(t, notBefore.Add(15*time.Minute).Equal(numericDate(t, token, "exp")))
+	})
+}
+
+func Test_PrepareToken_jti(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	jti := func(t *testing.T, input JWTIssuerFunctionInput, policy TokenPolicy) string {
+		t.Helper()
+		input.Claims = jwt.MapClaims{}
+		token, err := PrepareToken(input, signer, policy)
+		require.NoError(t, err)
+		return token.Claims.(jwt.MapClaims)["jti"].(string)
+	}
+
+	t.Run("uses the stack's format and prefix", func(t *testing.T) {
+		assert.Regexp(t, `^[0-9a-f-]{8}-[0-9a-f]{4}-4`, jti(t, JWTIssuerFunctionInput{SetJti: lo.ToPtr(true)}, TokenPolicy{}))
+		assert.Regexp(t, `^hs_[0-9a-f-]{8}-[0-9a-f]{4}-7`, jti(t, JWTIssuerFunctionInput{SetJti: lo.ToPtr(true)}, TokenPolicy{JTIFormat: JTIFormatUUIDv7, JTIPrefix: "hs_"}))
+	})
+
+	t.Run("uses the input's format and prefix", func(t *testing.T) {
+		policy := TokenPolicy{JTIFormat: JTIFormatUUIDv7, JTIPrefix: "hs_"}
+
+		assert.Regexp(t, `^hs_[0-9A-HJKMNP-TV-Z]{26}$`, jti(t, JWTIssuerFunctionInput{JTIFormat: lo.ToPtr(JTIFormatULID)}, policy))
+		assert.Regexp(t, `^tok-[0-9a-f-]{8}-[0-9a-f]{4}-7`, jti(t, JWTIssuerFunctionInput{JTIPrefix: lo.ToPtr("tok-")}, policy))
+		assert.Regexp(t, `^[0-9a-f-]{8}-[0-9a-f]{4}-7`, jti(t, JWTIssuerFunctionInput{SetJti: lo.ToPtr(true), JTIPrefix: lo.ToPtr("")}, policy))
+	})
+
+	t.Run("rejects unsupported formats", func(t *testing.T) {
+		_, err := PrepareToken(JWTIssuerFunctionInput{JTIFormat: lo.ToPtr(JTIFormat("snowflake"))}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: unsupported jti format "snowflake"`, err)
+	})
+}
+
+func Test_PrepareToken_expiresAt(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	t.Run("sets exp from a timestamp", func(t *testing.T) {
+		token, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"exp": float64(1)}, ExpiresAt: lo.ToPtr(Time("2030-01-02T03:04:05Z"))}, signer, TokenPolicy{})
+		require.NoError(t, err)
+		exp := token.Claims.(jwt.MapClaims)["exp"].(*jwt.NumericDate)
+		assert.Equal(t, int64(1893553445), exp.Unix())
+	})
+
+	t.Run("sets exp from a duration", func(t *testing.T) {
+		token, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ExpiresAt: lo.ToPtr(Time("1h"))}, signer, TokenPolicy{})
+		require.NoError(t, err)
+		exp := token.Claims.(jwt.MapClaims)["exp"].(*jwt.NumericDate)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), exp.Time, time.Second)
+	})
+
+	t.Run("is checked against the maximum TTL", func(t *testing.T) {
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ExpiresAt: lo.ToPtr(Time("2h"))}, signer, TokenPolicy{MaxTTL: time.Hour})
+		var issuerErr *Error
+		require.True(t, errors.As(err, &issuerErr))
+		assert.Equal(t, ErrorCodeClaimPolicyViolation, issuerErr.Code)
+	})
+
+	t.Run("rejects invalid times", func(t *testing.T) {
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration("60")), ExpiresAt: lo.ToPtr(Time("1h"))}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: specify either ttl or expiresAt, not both", err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ExpiresAt: lo.ToPtr(Time("tomorrow"))}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: invalid expiresAt: "tomorrow" is not an RFC 3339 timestamp or a duration`, err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration("P1M"))}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: invalid ttl: "P1M" uses years or months, which aren't supported in ISO 8601 durations`, err)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("true"))}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: invalid notBefore: "true" is not an RFC 3339 timestamp or a duration`, err)
+
+		var input JWTIssuerFunctionInput
+		require.NoError(t, json.Unmarshal([]byte(`{"claims":{},"expiresAt":1760000000}`), &input))
+		_, err = PrepareToken(input, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: invalid expiresAt: "1760000000" is a plain number, which could be a Unix time or a number of seconds from now, use an RFC 3339 timestamp or a duration like "1h" instead`, err)
+	})
+
+	t.Run("rejects tokens that would already be expired", func(t *testing.T) {
+		for _, ttl := range []string{"-3600", "-PT1H", "-1h", "0"} {
+			_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, TTL: lo.ToPtr(Duration(ttl))}, signer, TokenPolicy{})
+			assertErrorCode(t, ErrorCodeInvalidInput, "issuer: ttl must be positive, got "+ttl, err)
+		}
+
+		_, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ExpiresAt: lo.ToPtr(Time("2020-01-02T03:04:05Z"))}, signer, TokenPolicy{})
+		require.Error(t, err)
+		assert.Equal(t, ErrorCodeInvalidInput, ClassifyError(err).Code)
+		assert.Contains(t, err.Error(), "issuer: expiresAt of 2020-01-02T03:04:05Z is not after the token becomes valid")
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, ExpiresAt: lo.ToPtr(Time("-1h"))}, signer, TokenPolicy{})
+		assert.Equal(t, ErrorCodeInvalidInput, ClassifyError(err).Code)
+
+		// expiresAt must also be after notBefore.
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("1h")), ExpiresAt: lo.ToPtr(Time("30m"))}, signer, TokenPolicy{})
+		assert.Equal(t, ErrorCodeInvalidInput, ClassifyError(err).Code)
+
+		_, err = PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{}, NotBefore: lo.ToPtr(Time("1h")), ExpiresAt: lo.ToPtr(Time("90m"))}, signer, TokenPolicy{})
+		assert.NoError(t, err)
+	})
+}
+
+func Test_IssueJWT(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+	keyring, err := NewKeyring("", nil, signer)
+	require.NoError(t, err)
+
+	input := JWTIssuerFunctionInput{
+		Claims: jwt.MapClaims{"sub": "user-1"},
+		SetIat: lo.ToPtr(true),
+		SetJti: lo.ToPtr(true),
+		SetNbf: lo.ToPtr(true),
+		TTL:    lo.ToPtr(Duration("60")),
+	}
+
+	t.Run("only returns the token by default", func(t *testing.T) {
+		output, err := IssueJWT(context.Background(), keyring, TokenPolicy{}, input)
+		require.NoError(t, err)
+		assert.NotEmpty(t, output.Token)
+		assert.Equal(t, JWTIssuerFunctionOutput{Token: output.Token}, output)
+
+		encoded, err := json.Marshal(output)
+		require.NoError(t, err)
+		assert.JSONEq(t, fmt.Sprintf(`{"token":%q}`, output.Token), string(encoded))
+	})
+
+	t.Run("includes metadata", func(t *testing.T) {
+		input := input
+		input.Claims = jwt.MapClaims{"sub": "user-1"}
+		input.IncludeMetadata = lo.ToPtr(true)
+
+		output, err := IssueJWT(context.Background(), keyring, TokenPolicy{}, input)
+		require.NoError(t, err)
+
+		token, err := jwt.Parse(output.Token, func(*jwt.Token) (any, error) {
+			return privateKey.Public(), nil
+		})
+		require.NoError(t, err)
+		claims := token.Claims.(jwt.MapClaims)
+
+		assert.Equal(t, int64(claims["exp"].(float64)), *output.ExpiresAt)
+		assert.Equal(t, int64(claims["iat"].(float64)), *output.IssuedAt)
+		assert.Equal(t, int64(claims["nbf"].(float64)), *output.NotBefore)
+		assert.Equal(t, claims["jti"], *output.JTI)
+		assert.Equal(t, "local-key", *output.KeyID)
+		assert.Equal(t, "ES256", *output.Algorithm)
+
+		encoded, err := json.Marshal(output.Claims)
+		require.NoError(t, err)
+		var signedClaims jwt.MapClaims
+		require.NoError(t, json.Unmarshal(encoded, &signedClaims))
+		assert.Equal(t, claims, signedClaims)
+	})
+
+	t.Run("issues a token for each audience", func(t *testing.T) {
+		output, err := IssueJWT(context.Background(), keyring, TokenPolicy{}, JWTIssuerFunctionInput{
+			Claims:          jwt.MapClaims{"sub": "user-1"},
+			Audiences:       []string{"hotsock", "billing"},
+			SetJti:          lo.ToPtr(true),
+			IncludeMetadata: lo.ToPtr(true),
+		})
+		require.NoError(t, err)
+		assert.Empty(t, output.Token)
+		require.Len(t, output.Tokens, 2)
+
+		for _, audience := range []string{"hotsock", "billing"} {
+			audienceOutput := output.Tokens[audience]
+			token, err := jwt.Parse(audienceOutput.Token, func(*jwt.Token) (any, error) {
+				return privateKey.Public(), nil
+			}, jwt.WithAudience(audience))
+			require.NoError(t, err)
+			assert.Equal(t, token.Claims.(jwt.MapClaims)["jti"], *audienceOutput.JTI)
+		}
+		assert.NotEqual(t, *output.Tokens["hotsock"].JTI, *output.Tokens["billing"].JTI)
+
+		encoded, err := json.Marshal(JWTIssuerFunctionOutput{Tokens: map[string]JWTIssuerFunctionOutput{"hotsock": {Token: "a.b.c"}}})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"tokens":{"hotsock":{"token":"a.b.c"}}}`, string(encoded))
+	})
+
+	t.Run("omits metadata the token doesn't have", func(t *testing.T) {
+		output, err := IssueJWT(context.Background(), keyring, TokenPolicy{}, JWTIssuerFunctionInput{IncludeMetadata: lo.ToPtr(true)})
+		require.NoError(t, err)
+		assert.Nil(t, output.ExpiresAt)
+		assert.Nil(t, output.IssuedAt)
+		assert.Nil(t, output.NotBefore)
+		assert.Nil(t, output.JTI)
+		assert.Equal(t, "local-key", *output.KeyID)
+		assert.Equal(t, jwt.MapClaims{}, output.Claims)
+	})
+}
+
+func Test_PrepareAudienceTokens(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	t.Run("prepares a token for each audience", func(t *testing.T) {
+		claims := jwt.MapClaims{"sub": "user-1", "aud": "ignored"}
+		input := JWTIssuerFunctionInput{
+			Claims:    claims,
+			Audiences: []string{"hotsock", "billing", "search"},
+			SetIat:    lo.ToPtr(true),
+			SetJti:    lo.ToPtr(true),
+			TTL:       lo.ToPtr(Duration("60")),
+		}
+
+		tokens, err := PrepareAudienceTokens(input, signer, TokenPolicy{})
+		require.NoError(t, err)
+		require.Len(t, tokens, 3)
+
+		jtis := map[any]bool{}
+		for audience, token := range tokens {
+			tokenClaims := token.Claims.(jwt.MapClaims)
+			assert.Equal(t, audience, tokenClaims["aud"])
+			assert.Equal(t, "user-1", tokenClaims["sub"])
+			assert.Equal(t, tokens["hotsock"].Claims.(jwt.MapClaims)["iat"], tokenClaims["iat"])
+			assert.Equal(t, tokens["hotsock"].Claims.(jwt.MapClaims)["exp"], tokenClaims["exp"])
+			jtis[tokenClaims["jti"]] = true
+		}
+		assert.Len(t, jtis, 3)
+
+		// The input's claims aren't changed.
+		assert.Equal(t, jwt.MapClaims{"sub": "user-1", "aud": "ignored"}, claims)
+	})
+
+	t.Run("rejects invalid audiences", func(t *testing.T) {
+		_, err := PrepareAudienceTokens(JWTIssuerFunctionInput{Audiences: []string{}}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: audiences must not be empty", err)
+
+		_, err = PrepareAudienceTokens(JWTIssuerFunctionInput{Audiences: []string{"hotsock", ""}}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, "issuer: audiences[1] must not be empty", err)
+
+		_, err = PrepareAudienceTokens(JWTIssuerFunctionInput{Audiences: []string{"hotsock", "hotsock"}}, signer, TokenPolicy{})
+		assertErrorCode(t, ErrorCodeInvalidInput, `issuer: audiences[1] "hotsock" is a duplicate`, err)
+	})
+
+	t.Run("applies the policy to each token", func(t *testing.T) {
+		_, err := PrepareAudienceTokens(JWTIssuerFunctionInput{Audiences: []string{"hotsock"}}, signer, TokenPolicy{RequireExp: true})
+		assertErrorCode(t, ErrorCodeClaimPolicyViolation, "issuer: tokens must expire, set ttl or an exp claim", err)
+	})
+}
+
+func Test_ConfiguredTokenPolicy(t *testing.T) {
+	policy, err := ConfiguredTokenPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, TokenPolicy{JTIFormat: JTIFormatUUIDv4}, policy)
+
+	t.Setenv(DefaultTTLEnvVar, "300")
+	t.Setenv(MaxTokenTTLEnvVar, "3600")
+	t.Setenv(RequireExpEnvVar, "true")
+	t.Setenv(ClockSkewEnvVar, "30")
+	t.Setenv(JTIFormatEnvVar, "ULID")
+	t.Setenv(JTIPrefixEnvVar, "hs_")
+	policy, err = ConfiguredTokenPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, TokenPolicy{DefaultTTL: 5 * time.Minute, MaxTTL: time.Hour, RequireExp: true, ClockSkew: 30 * time.Second, JTIFormat: JTIFormatULID, JTIPrefix: "hs_"}, policy)
+
+	t.Setenv(DefaultTTLEnvVar, "7200")
+	_, err = ConfiguredTokenPolicy()
+	assert.ErrorContains(t, err, "exceeds MAX_TOKEN_TTL")
+
+	t.Setenv(DefaultTTLEnvVar, "300")
+	t.Setenv(JTIFormatEnvVar, "snowflake")
+	_, err = ConfiguredTokenPolicy()
+	assert.EqualError(t, err, `issuer: unsupported jti format "snowflake"`)
+}
+
+func assertErrorCode(t *testing.T, code ErrorCode, message string, err error) {
+	t.Helper()
+
+	var issuerErr *Error
+	require.True(t, errors.As(err, &issuerErr), "expected an issuer error, got %v", err)
+	assert.Equal(t, code, issuerErr.Code)
+	assert.Equal(t, message, err.Error())
+}
@@ -0,0 +1,92 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const KeyIDStrategyEnvVar = "KEY_ID_STRATEGY"
+
+// KeyIDStrategy determines how the "kid" for a key is derived.
+type KeyIDStrategy string
+
+const (
+	// KeyIDStrategyResourceID uses the identifier of the resource holding the
+	// key: the CloudFormation stack UUID for Parameter Store keys or the key ID
+	// for KMS keys.
+	KeyIDStrategyResourceID KeyIDStrategy = "ResourceID"
+
+	// KeyIDStrategyThumbprint uses the RFC 7638 SHA-256 JWK thumbprint of the
+	// public key, so the kid depends only on the key itself.
+	KeyIDStrategyThumbprint KeyIDStrategy = "Thumbprint"
+)
+
+// ConfiguredKeyIDStrategy returns the key ID strategy configured for the
+// stack, defaulting to KeyIDStrategyResourceID.
+func ConfiguredKeyIDStrategy() (KeyIDStrategy, error) {
+	strategy := KeyIDStrategy(os.Getenv(KeyIDStrategyEnvVar))
+	switch strategy {
+	case "":
+		return KeyIDStrategyResourceID, nil
+	case KeyIDStrategyResourceID, KeyIDStrategyThumbprint:
+		return strategy, nil
+	}
+
+	return "", fmt.Errorf("issuer: unsupported key ID strategy %q", strategy)
+}
+
+// KeyID returns the kid for a public key held by the resource with the given
+// identifier.
+func (s KeyIDStrategy) KeyID(resourceID string, publicKey crypto.PublicKey) (string, error) {
+	if s == KeyIDStrategyThumbprint {
+		return JWKThumbprint(publicKey)
+	}
+
+	return resourceID, nil
+}
+
+// JWKThumbprint returns the base64url-encoded RFC 7638 SHA-256 thumbprint of
+// the public key.
+func JWKThumbprint(publicKey crypto.PublicKey) (string, error) {
+	jwk, err := NewJWK(publicKey, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	// RFC 7638 Section 3.2: only the required members, in lexicographic order,
+	// with no whitespace. Struct fields are marshaled in declaration order.
+	var members any
+	switch jwk.KeyType {
+	case KeyTypeEC:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Curve, jwk.KeyType, jwk.X, jwk.Y}
+	case KeyTypeRSA:
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	case KeyTypeOKP:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	canonical, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canonical)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
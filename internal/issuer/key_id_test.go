@@ -0,0 +1,60 @@
+package issuer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_JWKThumbprint(t *testing.T) {
+	// Example from RFC 7638 Section 3.1.
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+	publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+	thumbprint, err := JWKThumbprint(publicKey)
+	require.NoError(t, err)
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", thumbprint)
+}
+
+func Test_KeyIDStrategy(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	t.Run("defaults to the resource ID", func(t *testing.T) {
+		t.Setenv(KeyIDStrategyEnvVar, "")
+		strategy, err := ConfiguredKeyIDStrategy()
+		require.NoError(t, err)
+
+		keyID, err := strategy.KeyID("d0a511e0", &privateKey.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, "d0a511e0", keyID)
+	})
+
+	t.Run("thumbprint", func(t *testing.T) {
+		t.Setenv(KeyIDStrategyEnvVar, "Thumbprint")
+		strategy, err := ConfiguredKeyIDStrategy()
+		require.NoError(t, err)
+
+		keyID, err := strategy.KeyID("d0a511e0", &privateKey.PublicKey)
+		require.NoError(t, err)
+
+		expected, err := JWKThumbprint(&privateKey.PublicKey)
+		require.NoError(t, err)
+		assert.Equal(t, expected, keyID)
+		assert.Len(t, keyID, 43)
+	})
+
+	t.Run("unsupported strategy", func(t *testing.T) {
+		t.Setenv(KeyIDStrategyEnvVar, "Random")
+		_, err := ConfiguredKeyIDStrategy()
+		require.Error(t, err)
+	})
+}
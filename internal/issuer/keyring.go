@@ -0,0 +1,616 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/samber/lo"
+)
+
+const SigningKeyArnEnvVar = "SIGNING_KEY_ARN"
+
+const (
+	CustodianKMS            = "KMS"
+	CustodianParameterStore = "ParameterStore"
+)
+
+// ErrKeyNotFound is returned when a request selects a key or profile that is
+// not in the keyring.
+var ErrKeyNotFound = errors.New("issuer: signing key not found")
+
+// ErrKeyringNotConfigured is returned by LoadKeyringConfig when the stack has
+// no keyring parameter.
+var ErrKeyringNotConfigured = errors.New("issuer: keyring parameter not found")
+
+// KeyringConfig is the JSON document stored in the keyring parameter. It lists
+// every key the issuer can sign with, which one is used by default, and named
+// profiles that map to keys.
+type KeyringConfig struct {
+	// The kid of the key used when a request doesn't select one. Defaults to
+	// the first key.
+	ActiveKeyID string `json:"activeKeyId,omitempty"`
+
+	// Named profiles that requests can use to select a key, mapped to kids.
+	Profiles map[string]string `json:"profiles,omitempty"`
+
+	Keys []KeyConfig `json:"keys"`
+}
+
+// KeyConfig describes a single key in the keyring.
+type KeyConfig struct {
+	// The kid for the key. If empty, it's derived with the stack's key ID
+	// strategy.
+	KeyID string `json:"kid,omitempty"`
+
+	// Either "KMS" or "ParameterStore".
+	Custodian string `json:"custodian"`
+
+	// The JWS algorithm for the key. Defaults to the stack's signing algorithm.
+	Algorithm string `json:"alg,omitempty"`
+
+	// The KMS key ARN, for KMS keys.
+	KeyArn string `json:"keyArn,omitempty"`
+
+	// Key ARNs of replicas of a multi-Region KMS key, in the order they're
+	// signed with when KMS in the key's region is throttling or failing.
+	ReplicaKeyArns []string `json:"replicaKeyArns,omitempty"`
+
+	// The parameter names holding the PEM-encoded keys, for Parameter Store
+	// keys.
+	PrivateKeyParameter string `json:"privateKeyParameter,omitempty"`
+	PublicKeyParameter  string `json:"publicKeyParameter,omitempty"`
+
+	// The lifecycle state of the key. Only active keys are used for signing,
+	// and retired and revoked keys aren't published. Defaults to a state
+	// inferred from the timestamps below, which is active for keys without any.
+	State KeyState `json:"state,omitempty"`
+
+	// When the key was created, became active, started retiring, was retired
+	// and was revoked.
+	CreatedAt     *time.Time `json:"createdAt,omitempty"`
+	ActivatedAt   *time.Time `json:"activatedAt,omitempty"`
+	DeactivatedAt *time.Time `json:"deactivatedAt,omitempty"`
+	RetiredAt     *time.Time `json:"retiredAt,omitempty"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty"`
+}
+
+// ConfiguredKeyCustodian returns the custodian of the key created with the
+// stack.
+func ConfiguredKeyCustodian() string {
+	if os.Getenv(SigningKeyArnEnvVar) != "" {
+		return CustodianKMS
+	}
+
+	return CustodianParameterStore
+}
+
+// DefaultKeyConfig returns the configuration of the key created with the
+// stack for the custodian.
+func DefaultKeyConfig(custodian string) KeyConfig {
+	if custodian == CustodianKMS {
+		return KeyConfig{
+			Custodian:      CustodianKMS,
+			KeyArn:         os.Getenv(SigningKeyArnEnvVar),
+			ReplicaKeyArns: ConfiguredReplicaKeyArns(),
+		}
+	}
+
+	return KeyConfig{
+		Custodian:           CustodianParameterStore,
+		PrivateKeyParameter: PrivateKeyParameterName(),
+		PublicKeyParameter:  PublicKeyParameterName(),
+	}
+}
+
+// LoadKeyringConfig reads the keyring parameter, returning
+// ErrKeyringNotConfigured if the stack doesn't have one.
+func LoadKeyringConfig(ctx context.Context, ssmClient SSMAPI) (KeyringConfig, error) {
+	getParamResponse, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: lo.ToPtr(KeyringParameterName()),
+	})
+	if err != nil {
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return KeyringConfig{}, ErrKeyringNotConfigured
+		}
+		return KeyringConfig{}, err
+	}
+
+	var config KeyringConfig
+	if err := json.Unmarshal([]byte(lo.FromPtr(getParamResponse.Parameter.Value)), &config); err != nil {
+		return KeyringConfig{}, fmt.Errorf("issuer: invalid keyring parameter: %w", err)
+	}
+
+	return config, nil
+}
+
+// PutKeyringParameter writes the keyring configuration to its Parameter Store
+// parameter, replacing any existing value.
+func PutKeyringParameter(ctx context.Context, ssmClient SSMAPI, config KeyringConfig) error {
+	value, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	_, err = ssmClient.PutParameter(ctx, &ssm.PutParameterInput{
+		DataType:    lo.ToPtr("text"),
+		Description: lo.ToPtr("JWT Issuer Keyring"),
+		Name:        lo.ToPtr(KeyringParameterName()),
+		Overwrite:   lo.ToPtr(true),
+		Type:        ssmtypes.ParameterTypeString,
+		Value:       lo.ToPtr(string(value)),
+	})
+
+	return err
+}
+
+// Keyring holds the signers available to the issuer.
+type Keyring struct {
+	signers     []Signer
+	byKeyID     map[string]Signer
+	activeKeyID string
+	profiles    map[string]string
+
+	// Keys in the configuration that can't be used for signing, with their
+	// states.
+	inactive map[string]KeyState
+}
+
+// NewKeyring returns a keyring for the signers. The active key defaults to
+// the first signer when activeKeyID is empty.
+func NewKeyring(activeKeyID string, profiles map[string]string, signers ...Signer) (*Keyring, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("issuer: keyring must contain at least one key")
+	}
+
+	byKeyID := map[string]Signer{}
+	for _, signer := range signers {
+		if _, ok := byKeyID[signer.KeyID()]; ok {
+			return nil, fmt.Errorf("issuer: keyring contains duplicate kid %q", signer.KeyID())
+		}
+		byKeyID[signer.KeyID()] = signer
+	}
+
+	if activeKeyID == "" {
+		activeKeyID = signers[0].KeyID()
+	}
+	if _, ok := byKeyID[activeKeyID]; !ok {
+		return nil, fmt.Errorf("issuer: active kid %q is not in the keyring", activeKeyID)
+	}
+
+	for profile, keyID := range profiles {
+		if _, ok := byKeyID[keyID]; !ok {
+			return nil, fmt.Errorf("issuer: profile %q refers to kid %q, which is not in the keyring", profile, keyID)
+		}
+	}
+
+	return &Keyring{
+		signers:     signers,
+		byKeyID:     byKeyID,
+		activeKeyID: activeKeyID,
+		profiles:    profiles,
+	}, nil
+}
+
+// Signer returns the signer selected by kid or profile, or the active signer
+// if neither is given.
+func (k *Keyring) Signer(keyID string, profile string) (Signer, error) {
+	if keyID != "" && profile != "" {
+		return nil, NewError(ErrorCodeInvalidInput, "issuer: specify either kid or profile, not both")
+	}
+
+	if profile != "" {
+		profileKeyID, ok := k.profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown profile %q", ErrKeyNotFound, profile)
+		}
+		keyID = profileKeyID
+	}
+
+	if keyID == "" {
+		keyID = k.activeKeyID
+	}
+
+	signer, ok := k.byKeyID[keyID]
+	if !ok {
+		if state, ok := k.inactive[keyID]; ok {
+			return nil, fmt.Errorf("%w: kid %q is %s", ErrKeyNotActive, keyID, state)
+		}
+		return nil, fmt.Errorf("%w: unknown kid %q", ErrKeyNotFound, keyID)
+	}
+
+	return signer, nil
+}
+
+// Signers returns every signer in the keyring, in configuration order.
+// Only active keys have signers.
+func (k *Keyring) Signers() []Signer {
+	return k.signers
+}
+
+// JWKSet returns a JWK Set with the public keys of every signer in the
+// keyring. Use KeyLoader.LoadJWKSet to include keys that aren't active.
+func (k *Keyring) JWKSet() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	for _, signer := range k.signers {
+		jwk, err := NewJWK(signer.PublicKey(), signer.KeyID(), signer.Algorithm())
+		if err != nil {
+			return JWKSet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// KeyLoader loads keys from Parameter Store and KMS.
+type KeyLoader struct {
+	SSM              SSMAPI
+	KMS              KMSAPI
+	KeyIDStrategy    KeyIDStrategy
+	KMSSignerOptions []KMSSignerOption
+
+	// Return the KMS client for a region, and count failures in each region
+	// across keyring reloads. Only needed for KMS keys with replicas.
+	KMSClientForRegion func(region string) KMSAPI
+	KMSCircuitBreaker  *CircuitBreaker
+}
+
+// LoadConfiguredKeyring loads the keyring described by the keyring parameter.
+// Stacks without one get a keyring holding only the key created with the stack
+// for the custodian.
+func (l *KeyLoader) LoadConfiguredKeyring(ctx context.Context, custodian string) (*Keyring, error) {
+	config, err := LoadKeyringConfig(ctx, l.SSM)
+	if errors.Is(err, ErrKeyringNotConfigured) {
+		config = KeyringConfig{Keys: []KeyConfig{DefaultKeyConfig(custodian)}}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return l.LoadKeyring(ctx, config)
+}
+
+// LoadKeyring loads a signer for every active key in the configuration.
+// Requests for keys in other states fail with ErrKeyNotActive.
+func (l *KeyLoader) LoadKeyring(ctx context.Context, config KeyringConfig) (*Keyring, error) {
+	signers := make([]Signer, 0, len(config.Keys))
+	inactive := map[string]KeyState{}
+	for _, keyConfig := range config.Keys {
+		state := keyConfig.LifecycleState()
+		if !state.Valid() {
+			return nil, fmt.Errorf("issuer: key %q has unknown state %q", keyConfig.KeyID, state)
+		}
+
+		if !state.CanSign() {
+			// Published keys may rely on the kid strategy, so their kid is derived
+			// from the public key. Material for other keys may have been deleted,
+			// and without a kid they can't be requested anyway.
+			keyID := keyConfig.KeyID
+			if keyID == "" && state.Published() {
+				jwk, err := l.LoadJWK(ctx, keyConfig)
+				if err != nil {
+					return nil, err
+				}
+				keyID = jwk.KeyID
+			}
+			if keyID != "" {
+				inactive[keyID] = state
+			}
+			continue
+		}
+
+		signer, err := l.LoadSigner(ctx, keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	if state, ok := inactive[config.ActiveKeyID]; ok && config.ActiveKeyID != "" {
+		return nil, fmt.Errorf("issuer: active kid %q is %s", config.ActiveKeyID, state)
+	}
+
+	// Profiles may refer to keys that aren't active. They're checked when a
+	// request uses them.
+	activeProfiles := lo.PickBy(config.Profiles, func(_ string, keyID string) bool {
+		_, ok := inactive[keyID]
+		return !ok
+	})
+
+	keyring, err := NewKeyring(config.ActiveKeyID, activeProfiles, signers...)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring.inactive = inactive
+	for profile, keyID := range config.Profiles {
+		keyring.profiles[profile] = keyID
+	}
+
+	return keyring, nil
+}
+
+// LoadSigner loads a signer for a single key. Before it's returned, the signer
+// signs a probe that's verified with the key's published public key, so keys
+// that don't match their configuration are never used.
+func (l *KeyLoader) LoadSigner(ctx context.Context, keyConfig KeyConfig) (Signer, error) {
+	alg, err := keyConfigAlgorithm(keyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	keyConfig, err = l.resolveKMSKeyArn(ctx, keyConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer Signer
+	var publishedKey crypto.PublicKey
+
+	switch keyConfig.Custodian {
+	case CustodianKMS:
+		replicas, err := l.kmsReplicas(keyConfig)
+		if err != nil {
+			return nil, err
+		}
+		publicKey, err := LoadKMSSigningKey(ctx, l.KMS, alg, keyConfig.KeyArn)
+		for i := 0; err != nil && i < len(replicas) && isKMSFailoverError(ctx, err); i++ {
+			// Replicas share the key's material, so a cold start during an
+			// outage in the key's region can load it from a replica.
+			slog.WarnContext(ctx, "issuer.KeyLoader: loading KMS key from replica", "kmsKeyArn", replicas[i].KeyArn, "error", err)
+			publicKey, err = LoadKMSSigningKey(ctx, replicas[i].Client, alg, replicas[i].KeyArn)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keyID, err := l.keyID(keyConfig, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		opts := l.KMSSignerOptions
+		if len(replicas) > 0 {
+			opts = append(slices.Clip(opts), WithReplicas(l.KMSCircuitBreaker, replicas...))
+		}
+		signer, err = NewKMSSigner(l.KMS, alg, keyConfig.KeyArn, keyID, publicKey, opts...)
+		if err != nil {
+			return nil, err
+		}
+		publishedKey = publicKey
+	case CustodianParameterStore:
+		privateKeyPEM, err := l.getParameter(ctx, keyConfig.PrivateKeyParameter)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err := ParsePrivateKeyPEM(privateKeyPEM)
+		if err != nil {
+			return nil, err
+		}
+		keyID, err := l.keyID(keyConfig, privateKey.Public())
+		if err != nil {
+			return nil, err
+		}
+		signer, err = NewLocalSigner(alg, privateKey, keyID)
+		if err != nil {
+			return nil, err
+		}
+		publishedKey, err = l.LoadPublicKey(ctx, keyConfig)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("issuer: unsupported key custodian %q", keyConfig.Custodian)
+	}
+
+	if err := SelfTest(ctx, signer, publishedKey); err != nil {
+		return nil, err
+	}
+
+	return signer, nil
+}
+
+// ConfiguredJWKSet returns the JWK Set for the keys in the keyring parameter,
+// or the fallback set if the stack has no keyring parameter.
+func (l *KeyLoader) ConfiguredJWKSet(ctx context.Context, fallback JWKSet) (JWKSet, error) {
+	config, err := LoadKeyringConfig(ctx, l.SSM)
+	if errors.Is(err, ErrKeyringNotConfigured) {
+		return fallback, nil
+	} else if err != nil {
+		return JWKSet{}, err
+	}
+
+	return l.LoadJWKSet(ctx, config)
+}
+
+// SyncJWKSParameter writes the JWK Set for the configuration to its parameter
+// if the parameter doesn't already hold it, reporting whether it was written.
+// This is how changes that don't otherwise touch the JWK Set, such as
+// revoking a key that isn't active, reach verifiers.
+func (l *KeyLoader) SyncJWKSParameter(ctx context.Context, config KeyringConfig) (bool, error) {
+	jwks, err := l.LoadJWKSet(ctx, config)
+	if err != nil {
+		return false, err
+	}
+
+	value, err := json.Marshal(jwks)
+	if err != nil {
+		return false, err
+	}
+
+	published, err := l.getParameter(ctx, JWKSParameterName())
+	var notFound *ssmtypes.ParameterNotFound
+	if err != nil && !errors.As(err, &notFound) {
+		return false, err
+	}
+	if err == nil && string(published) == string(value) {
+		return false, nil
+	}
+
+	return true, PutJWKSParameter(ctx, l.SSM, jwks)
+}
+
+// LoadJWKSet loads the public key of every published key in the configuration
+// without accessing private key material, and returns them as a JWK Set.
+// Retired and revoked keys are left out.
+func (l *KeyLoader) LoadJWKSet(ctx context.Context, config KeyringConfig) (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+	for _, keyConfig := range config.Keys {
+		if !keyConfig.LifecycleState().Published() {
+			continue
+		}
+
+		jwk, err := l.LoadJWK(ctx, keyConfig)
+		if err != nil {
+			return JWKSet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
+
+// LoadJWK loads the public key for a single key and returns it as a JWK.
+func (l *KeyLoader) LoadJWK(ctx context.Context, keyConfig KeyConfig) (JWK, error) {
+	alg, err := keyConfigAlgorithm(keyConfig)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	keyConfig, err = l.resolveKMSKeyArn(ctx, keyConfig)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	publicKey, err := l.LoadPublicKey(ctx, keyConfig)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	if err := alg.CheckPublicKey(publicKey); err != nil {
+		return JWK{}, err
+	}
+
+	keyID, err := l.keyID(keyConfig, publicKey)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	return NewJWK(publicKey, keyID, alg.Name())
+}
+
+// LoadPublicKey loads the public key for a single key without accessing
+// private key material.
+func (l *KeyLoader) LoadPublicKey(ctx context.Context, keyConfig KeyConfig) (crypto.PublicKey, error) {
+	switch keyConfig.Custodian {
+	case CustodianKMS:
+		return LoadKMSPublicKey(ctx, l.KMS, keyConfig.KeyArn)
+	case CustodianParameterStore:
+		publicKeyPEM, err := l.getParameter(ctx, keyConfig.PublicKeyParameter)
+		if err != nil {
+			return nil, err
+		}
+		return ParsePublicKeyPEM(publicKeyPEM)
+	}
+
+	return nil, fmt.Errorf("issuer: unsupported key custodian %q", keyConfig.Custodian)
+}
+
+// keyID returns the configured kid for the key, or derives one with the key
+// ID strategy. Without an explicit kid, Parameter Store keys use the stack's
+// resource ID.
+func (l *KeyLoader) keyID(keyConfig KeyConfig, publicKey crypto.PublicKey) (string, error) {
+	if keyConfig.KeyID != "" {
+		return keyConfig.KeyID, nil
+	}
+
+	if keyConfig.Custodian == CustodianKMS {
+		resourceID, err := KMSKeyResourceID(keyConfig.KeyArn)
+		if err != nil {
+			return "", err
+		}
+		return l.KeyIDStrategy.KeyID(resourceID, publicKey)
+	}
+
+	return l.KeyIDStrategy.KeyID(ParameterStoreKeyID(), publicKey)
+}
+
+// resolveKMSKeyArn returns the key configuration with an alias ARN for a KMS
+// key replaced by the key's ARN.
+func (l *KeyLoader) resolveKMSKeyArn(ctx context.Context, keyConfig KeyConfig) (KeyConfig, error) {
+	if keyConfig.Custodian != CustodianKMS {
+		return keyConfig, nil
+	}
+
+	keyArn, err := ResolveKMSKeyArn(ctx, l.KMS, keyConfig.KeyArn)
+	if err != nil {
+		return KeyConfig{}, err
+	}
+	keyConfig.KeyArn = keyArn.String()
+
+	return keyConfig, nil
+}
+
+// kmsReplicas returns the replicas of a KMS key, with the client for each
+// replica's region. The key created with the stack uses the stack's replicas
+// unless the keyring lists its own, so keyrings written by the key rotator or
+// by hand don't turn off failover.
+func (l *KeyLoader) kmsReplicas(keyConfig KeyConfig) ([]KMSReplica, error) {
+	if len(keyConfig.ReplicaKeyArns) == 0 && keyConfig.KeyArn == os.Getenv(SigningKeyArnEnvVar) {
+		keyConfig.ReplicaKeyArns = ConfiguredReplicaKeyArns()
+	}
+	if len(keyConfig.ReplicaKeyArns) == 0 {
+		return nil, nil
+	}
+
+	primary, err := ParseKMSKeyArn(keyConfig.KeyArn)
+	if err != nil {
+		return nil, err
+	}
+
+	replicaArns, err := CheckReplicaKeyArns(primary, keyConfig.ReplicaKeyArns)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.KMSClientForRegion == nil {
+		return nil, fmt.Errorf("issuer: KMS key %s has replicas, but there's no KMS client for their regions", primary)
+	}
+
+	replicas := make([]KMSReplica, 0, len(replicaArns))
+	for _, replicaArn := range replicaArns {
+		replicas = append(replicas, KMSReplica{
+			KeyArn: replicaArn.String(),
+			Client: l.KMSClientForRegion(replicaArn.Region),
+		})
+	}
+
+	return replicas, nil
+}
+
+func (l *KeyLoader) getParameter(ctx context.Context, name string) ([]byte, error) {
+	getParamResponse, err := l.SSM.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           lo.ToPtr(name),
+		WithDecryption: lo.ToPtr(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(lo.FromPtr(getParamResponse.Parameter.Value)), nil
+}
+
+func keyConfigAlgorithm(keyConfig KeyConfig) (SigningAlgorithm, error) {
+	if keyConfig.Algorithm == "" {
+		return ConfiguredSigningAlgorithm()
+	}
+
+	return LookupSigningAlgorithm(keyConfig.Algorithm)
+}
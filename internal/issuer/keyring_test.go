@@ -0,0 +1,382 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/smithy-go"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Keyring(t *testing.T) {
+	newSigner := func(name string, keyID string) Signer {
+		alg, err := LookupSigningAlgorithm(name)
+		require.NoError(t, err)
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		signer, err := NewLocalSigner(alg, privateKey, keyID)
+		require.NoError(t, err)
+		return signer
+	}
+
+	first := newSigner("ES256", "first")
+	second := newSigner("EdDSA", "second")
+
+	t.Run("selects the active key by default", func(t *testing.T) {
+		keyring, err := NewKeyring("", nil, first, second)
+		require.NoError(t, err)
+
+		signer, err := keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.Equal(t, "first", signer.KeyID())
+
+		keyring, err = NewKeyring("second", nil, first, second)
+		require.NoError(t, err)
+
+		signer, err = keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.Equal(t, "second", signer.KeyID())
+	})
+
+	t.Run("selects keys by kid or profile", func(t *testing.T) {
+		keyring, err := NewKeyring("first", map[string]string{"hotsock": "second"}, first, second)
+		require.NoError(t, err)
+
+		signer, err := keyring.Signer("second", "")
+		require.NoError(t, err)
+		assert.Equal(t, "second", signer.KeyID())
+
+		signer, err = keyring.Signer("", "hotsock")
+		require.NoError(t, err)
+		assert.Equal(t, "second", signer.KeyID())
+
+		_, err = keyring.Signer("missing", "")
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+		_, err = keyring.Signer("", "missing")
+		assert.True(t, errors.Is(err, ErrKeyNotFound))
+
+		_, err = keyring.Signer("first", "hotsock")
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects invalid configurations", func(t *testing.T) {
+		_, err := NewKeyring("", nil)
+		assert.Error(t, err)
+
+		_, err = NewKeyring("", nil, first, first)
+		assert.ErrorContains(t, err, "duplicate kid")
+
+		_, err = NewKeyring("missing", nil, first)
+		assert.ErrorContains(t, err, "active kid")
+
+		_, err = NewKeyring("", map[string]string{"hotsock": "missing"}, first)
+		assert.ErrorContains(t, err, "profile")
+	})
+
+	t.Run("publishes every key", func(t *testing.T) {
+		keyring, err := NewKeyring("", nil, first, second)
+		require.NoError(t, err)
+
+		set, err := keyring.JWKSet()
+		require.NoError(t, err)
+		require.Len(t, set.Keys, 2)
+		assert.Equal(t, "first", set.Keys[0].KeyID)
+		assert.Equal(t, "ES256", set.Keys[0].Algorithm)
+		assert.Equal(t, "second", set.Keys[1].KeyID)
+		assert.Equal(t, "EdDSA", set.Keys[1].Algorithm)
+	})
+}
+
+func Test_KeyLoader(t *testing.T) {
+	t.Setenv(StackArnEnvVar, "arn:aws:cloudformation:us-east-1:111111111111:stack/JWTIssuer/d9385410-50ee-11ee-b05b-0a236ebfa8d3")
+
+	psAlg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	psKey, err := psAlg.GenerateKey()
+	require.NoError(t, err)
+	psPrivateKeyPEM, err := EncodePrivateKeyPEM(psKey)
+	require.NoError(t, err)
+	psPublicKeyPEM, err := EncodePublicKeyPEM(psKey.Public())
+	require.NoError(t, err)
+
+	kmsAlg, err := LookupSigningAlgorithm("RS256")
+	require.NoError(t, err)
+	kmsKey, err := kmsAlg.GenerateKey()
+	require.NoError(t, err)
+	kmsPublicKeyDER, err := x509.MarshalPKIXPublicKey(kmsKey.Public())
+	require.NoError(t, err)
+	kmsKeyArn := "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b"
+
+	keyringJSON := `{
+		"activeKeyId": "kms-key",
+		"profiles": {"legacy": "d9385410-50ee-11ee-b05b-0a236ebfa8d3"},
+		"keys": [
+			{"custodian": "ParameterStore", "privateKeyParameter": "` + PrivateKeyParameterName() + `", "publicKeyParameter": "` + PublicKeyParameterName() + `"},
+			{"kid": "kms-key", "custodian": "KMS", "alg": "RS256", "keyArn": "` + kmsKeyArn + `"}
+		]
+	}`
+
+	parameterValues := map[string]string{
+		KeyringParameterName():    keyringJSON,
+		PrivateKeyParameterName(): string(psPrivateKeyPEM),
+		PublicKeyParameterName():  string(psPublicKeyPEM),
+	}
+
+	kmsSign := func(_ context.Context, input *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+		digest := sha256.Sum256(input.Message)
+		signature, err := kmsKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+		return &kms.SignOutput{Signature: signature}, err
+	}
+
+	newLoader := func(parameterValues map[string]string) *KeyLoader {
+		mockSSM := mocks.SSMAPI{}
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+			value, ok := parameterValues[lo.FromPtr(input.Name)]
+			if !ok {
+				return nil, &ssmtypes.ParameterNotFound{}
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(value)}}, nil
+		})
+
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("GetPublicKey", mock.Anything, mock.Anything).Return(&kms.GetPublicKeyOutput{
+			KeyId:             lo.ToPtr(kmsKeyArn),
+			KeySpec:           kmstypes.KeySpecRsa2048,
+			KeyUsage:          kmstypes.KeyUsageTypeSignVerify,
+			PublicKey:         kmsPublicKeyDER,
+			SigningAlgorithms: []kmstypes.SigningAlgorithmSpec{kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256},
+		}, nil)
+		mockKMS.On("Sign", mock.Anything, mock.Anything).Return(kmsSign)
+
+		return &KeyLoader{SSM: &mockSSM, KMS: &mockKMS, KeyIDStrategy: KeyIDStrategyResourceID}
+	}
+
+	t.Run("loads a mixed keyring", func(t *testing.T) {
+		keyring, err := newLoader(parameterValues).LoadConfiguredKeyring(context.Background(), CustodianParameterStore)
+		require.NoError(t, err)
+		require.Len(t, keyring.Signers(), 2)
+
+		signer, err := keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.Equal(t, "kms-key", signer.KeyID())
+		assert.Equal(t, "RS256", signer.Algorithm())
+		assert.IsType(t, &KMSSigner{}, signer)
+
+		signer, err = keyring.Signer("", "legacy")
+		require.NoError(t, err)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", signer.KeyID())
+		assert.Equal(t, "ES256", signer.Algorithm())
+		assert.IsType(t, &LocalSigner{}, signer)
+	})
+
+	t.Run("publishes a mixed keyring without private keys", func(t *testing.T) {
+		publicOnly := lo.OmitByKeys(parameterValues, []string{PrivateKeyParameterName()})
+
+		set, err := newLoader(publicOnly).ConfiguredJWKSet(context.Background(), JWKSet{})
+		require.NoError(t, err)
+		require.Len(t, set.Keys, 2)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", set.Keys[0].KeyID)
+		assert.Equal(t, "EC", set.Keys[0].KeyType)
+		assert.Equal(t, "kms-key", set.Keys[1].KeyID)
+		assert.Equal(t, "RSA", set.Keys[1].KeyType)
+	})
+
+	t.Run("resolves KMS alias ARNs", func(t *testing.T) {
+		aliasArn := "arn:aws:kms:us-east-1:111111111111:alias/jwt-issuer"
+		loader := newLoader(parameterValues)
+		mockKMS := loader.KMS.(*mocks.KMSAPI)
+		mockKMS.On("DescribeKey", mock.Anything, &kms.DescribeKeyInput{KeyId: lo.ToPtr(aliasArn)}).Return(&kms.DescribeKeyOutput{
+			KeyMetadata: &kmstypes.KeyMetadata{Arn: lo.ToPtr(kmsKeyArn)},
+		}, nil)
+
+		keyConfig := KeyConfig{Custodian: CustodianKMS, Algorithm: "RS256", KeyArn: aliasArn}
+		signer, err := loader.LoadSigner(context.Background(), keyConfig)
+		require.NoError(t, err)
+		assert.Equal(t, "4a2c1b37-e4c8-466a-b873-11aaf144b01b", signer.KeyID())
+
+		jwk, err := loader.LoadJWK(context.Background(), keyConfig)
+		require.NoError(t, err)
+		assert.Equal(t, "4a2c1b37-e4c8-466a-b873-11aaf144b01b", jwk.KeyID)
+
+		// Tokens are signed with the key the alias pointed to when it was loaded.
+		for _, call := range mockKMS.Calls {
+			if call.Method == "Sign" {
+				assert.Equal(t, kmsKeyArn, lo.FromPtr(call.Arguments.Get(1).(*kms.SignInput).KeyId))
+			}
+		}
+	})
+
+	t.Run("rejects malformed KMS key ARNs", func(t *testing.T) {
+		_, err := newLoader(parameterValues).LoadSigner(context.Background(), KeyConfig{Custodian: CustodianKMS, Algorithm: "RS256", KeyArn: "4a2c1b37-e4c8-466a-b873-11aaf144b01b"})
+		assert.ErrorContains(t, err, "malformed KMS key ARN")
+	})
+
+	t.Run("loads and signs with multi-Region KMS key replicas", func(t *testing.T) {
+		mrkArn := "arn:aws:kms:us-east-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+		replicaArn := "arn:aws:kms:us-west-2:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+		throttled := &smithy.GenericAPIError{Code: "ThrottlingException"}
+
+		loader := newLoader(parameterValues)
+		replicaKMS := loader.KMS.(*mocks.KMSAPI)
+		replicaKMS.On("Sign", mock.Anything, mock.Anything, mock.Anything).Return(kmsSign)
+
+		primaryKMS := mocks.KMSAPI{}
+		primaryKMS.On("GetPublicKey", mock.Anything, mock.Anything).Return(nil, throttled)
+		primaryKMS.On("Sign", mock.Anything, mock.Anything, mock.Anything).Return(nil, throttled)
+		loader.KMS = &primaryKMS
+
+		var regions []string
+		loader.KMSClientForRegion = func(region string) KMSAPI {
+			regions = append(regions, region)
+			return replicaKMS
+		}
+
+		keyConfig := KeyConfig{Custodian: CustodianKMS, Algorithm: "RS256", KeyArn: mrkArn, ReplicaKeyArns: []string{replicaArn}}
+		signer, err := loader.LoadSigner(context.Background(), keyConfig)
+		require.NoError(t, err)
+		assert.Equal(t, "mrk-1234abcd12ab34cd56ef1234567890ab", signer.KeyID())
+		assert.Equal(t, []string{"us-west-2"}, regions)
+
+		for _, call := range replicaKMS.Calls {
+			if call.Method == "Sign" {
+				assert.Equal(t, replicaArn, lo.FromPtr(call.Arguments.Get(1).(*kms.SignInput).KeyId))
+			}
+		}
+
+		loader.KMSClientForRegion = nil
+		_, err = loader.LoadSigner(context.Background(), keyConfig)
+		assert.ErrorContains(t, err, "no KMS client for their regions")
+
+		keyConfig.KeyArn = kmsKeyArn
+		_, err = loader.LoadSigner(context.Background(), keyConfig)
+		assert.ErrorContains(t, err, "isn't a multi-Region key")
+	})
+
+	t.Run("uses the stack's replicas for the stack key in a keyring", func(t *testing.T) {
+		mrkArn := "arn:aws:kms:us-east-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+		replicaArn := "arn:aws:kms:us-west-2:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+		t.Setenv(SigningKeyArnEnvVar, mrkArn)
+		t.Setenv(SigningKeyReplicaArnsEnvVar, replicaArn)
+
+		loader := newLoader(parameterValues)
+		loader.KMS.(*mocks.KMSAPI).On("Sign", mock.Anything, mock.Anything, mock.Anything).Return(kmsSign)
+		loader.KMSClientForRegion = func(string) KMSAPI { return loader.KMS }
+
+		// Keyrings written by the key rotator don't list the stack's replicas.
+		signer, err := loader.LoadSigner(context.Background(), KeyConfig{Custodian: CustodianKMS, Algorithm: "RS256", KeyArn: mrkArn})
+		require.NoError(t, err)
+		require.Len(t, signer.(*KMSSigner).replicas, 1)
+		assert.Equal(t, replicaArn, signer.(*KMSSigner).replicas[0].keyArn)
+
+		// Other keys only fail over to their own replicas.
+		signer, err = loader.LoadSigner(context.Background(), KeyConfig{Custodian: CustodianKMS, Algorithm: "RS256", KeyArn: kmsKeyArn})
+		require.NoError(t, err)
+		assert.Empty(t, signer.(*KMSSigner).replicas)
+	})
+
+	t.Run("falls back to the stack key without a keyring parameter", func(t *testing.T) {
+		withoutKeyring := lo.OmitByKeys(parameterValues, []string{KeyringParameterName()})
+
+		keyring, err := newLoader(withoutKeyring).LoadConfiguredKeyring(context.Background(), CustodianParameterStore)
+		require.NoError(t, err)
+		require.Len(t, keyring.Signers(), 1)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", keyring.Signers()[0].KeyID())
+
+		fallback := JWKSet{Keys: []JWK{{KeyID: "fallback"}}}
+		set, err := newLoader(withoutKeyring).ConfiguredJWKSet(context.Background(), fallback)
+		require.NoError(t, err)
+		assert.Equal(t, fallback, set)
+	})
+
+	t.Run("only signs with active keys and publishes unrevoked keys", func(t *testing.T) {
+		lifecycleJSON := `{
+			"activeKeyId": "kms-key",
+			"profiles": {"legacy": "d9385410-50ee-11ee-b05b-0a236ebfa8d3"},
+			"keys": [
+				{"custodian": "ParameterStore", "state": "retiring", "privateKeyParameter": "` + PrivateKeyParameterName() + `", "publicKeyParameter": "` + PublicKeyParameterName() + `"},
+				{"kid": "kms-key", "custodian": "KMS", "alg": "RS256", "state": "active", "keyArn": "` + kmsKeyArn + `"},
+				{"kid": "revoked-key", "custodian": "KMS", "alg": "RS256", "state": "revoked", "keyArn": "` + kmsKeyArn + `"}
+			]
+		}`
+		values := lo.Assign(parameterValues, map[string]string{KeyringParameterName(): lifecycleJSON})
+
+		keyring, err := newLoader(values).LoadConfiguredKeyring(context.Background(), CustodianParameterStore)
+		require.NoError(t, err)
+		require.Len(t, keyring.Signers(), 1)
+
+		_, err = keyring.Signer("d9385410-50ee-11ee-b05b-0a236ebfa8d3", "")
+		assert.True(t, errors.Is(err, ErrKeyNotActive))
+
+		_, err = keyring.Signer("", "legacy")
+		assert.True(t, errors.Is(err, ErrKeyNotActive))
+
+		_, err = keyring.Signer("revoked-key", "")
+		assert.True(t, errors.Is(err, ErrKeyNotActive))
+
+		set, err := newLoader(values).ConfiguredJWKSet(context.Background(), JWKSet{})
+		require.NoError(t, err)
+		require.Len(t, set.Keys, 2)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", set.Keys[0].KeyID)
+		assert.Equal(t, "kms-key", set.Keys[1].KeyID)
+	})
+
+	t.Run("ignores retired keys without a kid", func(t *testing.T) {
+		retiredJSON := `{"keys": [
+			{"kid": "kms-key", "custodian": "KMS", "alg": "RS256", "keyArn": "` + kmsKeyArn + `"},
+			{"custodian": "ParameterStore", "state": "retired", "privateKeyParameter": "/deleted/private-key", "publicKeyParameter": "/deleted/public-key"}
+		]}`
+		values := lo.Assign(parameterValues, map[string]string{KeyringParameterName(): retiredJSON})
+
+		keyring, err := newLoader(values).LoadConfiguredKeyring(context.Background(), CustodianKMS)
+		require.NoError(t, err)
+
+		signer, err := keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.Equal(t, "kms-key", signer.KeyID())
+	})
+
+	t.Run("rejects an inactive active key", func(t *testing.T) {
+		revokedJSON := `{"activeKeyId": "kms-key", "keys": [{"kid": "kms-key", "custodian": "KMS", "alg": "RS256", "state": "revoked", "keyArn": "` + kmsKeyArn + `"}]}`
+		values := lo.Assign(parameterValues, map[string]string{KeyringParameterName(): revokedJSON})
+
+		_, err := newLoader(values).LoadConfiguredKeyring(context.Background(), CustodianParameterStore)
+		assert.ErrorContains(t, err, "revoked")
+
+		invalidJSON := `{"keys": [{"kid": "kms-key", "custodian": "KMS", "state": "disabled", "keyArn": "` + kmsKeyArn + `"}]}`
+		values = lo.Assign(parameterValues, map[string]string{KeyringParameterName(): invalidJSON})
+
+		_, err = newLoader(values).LoadConfiguredKeyring(context.Background(), CustodianParameterStore)
+		assert.Error(t, err)
+	})
+
+	t.Run("rejects keys that don't match their published public key", func(t *testing.T) {
+		otherKey, err := psAlg.GenerateKey()
+		require.NoError(t, err)
+		otherPublicKeyPEM, err := EncodePublicKeyPEM(otherKey.Public())
+		require.NoError(t, err)
+
+		values := lo.Assign(parameterValues, map[string]string{PublicKeyParameterName(): string(otherPublicKeyPEM)})
+
+		_, err = newLoader(values).LoadSigner(context.Background(), DefaultKeyConfig(CustodianParameterStore))
+		assert.ErrorContains(t, err, "doesn't match the published public key")
+	})
+
+	t.Run("rejects unknown custodians", func(t *testing.T) {
+		_, err := newLoader(parameterValues).LoadSigner(context.Background(), KeyConfig{Custodian: "Vault"})
+		assert.ErrorContains(t, err, "unsupported key custodian")
+	})
+}
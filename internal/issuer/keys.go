@@ -0,0 +1,75 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// ParsePrivateKeyPEM parses a PEM-encoded PKCS #8, SEC 1 (EC) or PKCS #1 (RSA)
+// private key.
+func ParsePrivateKeyPEM(privateKeyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, errors.New("issuer: private key must be PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("issuer: unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("issuer: unable to parse %q private key", block.Type)
+}
+
+// EncodePrivateKeyPEM encodes a private key as PKCS #8 PEM.
+func EncodePrivateKeyPEM(privateKey crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// EC keys have always been written with an "EC PRIVATE KEY" label, even
+	// though the contents are PKCS #8. Keep doing so for consistency with
+	// existing stacks.
+	blockType := "PRIVATE KEY"
+	if _, ok := privateKey.(*ecdsa.PrivateKey); ok {
+		blockType = "EC PRIVATE KEY"
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), nil
+}
+
+// ParsePublicKeyPEM parses a PEM-encoded PKIX public key.
+func ParsePublicKeyPEM(publicKeyPEM []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return nil, errors.New("issuer: public key must be PEM encoded")
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// EncodePublicKeyPEM encodes a public key as PKIX PEM.
+func EncodePublicKeyPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
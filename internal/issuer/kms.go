@@ -2,61 +2,284 @@ package issuer
 
 import (
 	"context"
+	"crypto"
+	"crypto/x509"
 	"encoding/asn1"
-	"encoding/base64"
+	"fmt"
 	"log/slog"
 	"math/big"
-	"strings"
+	"os"
+	"slices"
 
 	"github.com/aws/aws-sdk-go-v2/service/kms"
 	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/samber/lo"
 )
 
 type KMSAPI interface {
+	CreateKey(context.Context, *kms.CreateKeyInput, ...func(*kms.Options)) (*kms.CreateKeyOutput, error)
+	DescribeKey(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)
 	GetPublicKey(context.Context, *kms.GetPublicKeyInput, ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error)
+	ScheduleKeyDeletion(context.Context, *kms.ScheduleKeyDeletionInput, ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error)
 	Sign(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error)
 }
 
-// SignJWTWithKMS signs a JWT using a private key that is known only to KMS
-func SignJWTWithKMS(ctx context.Context, kmsClient KMSAPI, token *jwt.Token, kmsKeyArn string) (string, error) {
-	defer LogWithTiming(ctx, slog.LevelDebug, "issuer.SignJWTWithKMS", "token", token, "kmsKeyArn", kmsKeyArn)()
+// DERSignatureError is returned when KMS responds with a signature that is not
+// a well-formed DER-encoded ECDSA signature.
+type DERSignatureError struct {
+	Reason string
+	Err    error
+}
+
+func (e *DERSignatureError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("issuer: malformed DER signature: %s: %v", e.Reason, e.Err)
+	}
+	return fmt.Sprintf("issuer: malformed DER signature: %s", e.Reason)
+}
+
+func (e *DERSignatureError) Unwrap() error {
+	return e.Err
+}
+
+const KMSDigestModeEnvVar = "KMS_DIGEST_MODE"
+
+// kmsMaxRawMessageSize is the largest message KMS signs with MessageTypeRaw.
+const kmsMaxRawMessageSize = 4096
+
+// KMSDigestMode determines when the signing string is hashed locally and sent
+// to KMS as a digest rather than as the raw message.
+type KMSDigestMode string
+
+const (
+	// KMSDigestModeAuto sends signing strings of up to 4096 bytes as raw
+	// messages, and hashes larger ones locally.
+	KMSDigestModeAuto KMSDigestMode = "Auto"
+
+	// KMSDigestModeAlways hashes every signing string locally, so only the
+	// digest is sent to KMS.
+	KMSDigestModeAlways KMSDigestMode = "Always"
+)
+
+// ConfiguredKMSDigestMode returns the KMS digest mode configured for the
+// stack, defaulting to KMSDigestModeAuto.
+func ConfiguredKMSDigestMode() (KMSDigestMode, error) {
+	mode := KMSDigestMode(os.Getenv(KMSDigestModeEnvVar))
+	switch mode {
+	case "":
+		return KMSDigestModeAuto, nil
+	case KMSDigestModeAuto, KMSDigestModeAlways:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("issuer: unsupported KMS digest mode %q", mode)
+}
+
+// KMSSigner signs using a private key that is known only to KMS.
+type KMSSigner struct {
+	alg              SigningAlgorithm
+	client           KMSAPI
+	keyArn           string
+	keyID            string
+	publicKey        crypto.PublicKey
+	verifySignatures bool
+	digestMode       KMSDigestMode
+	replicas         []kmsEndpoint
+	breaker          *CircuitBreaker
+}
+
+type KMSSignerOption func(*KMSSigner)
 
-	sstr, err := token.SigningString()
+// WithDigestMode sets when signing strings are hashed locally before they're
+// sent to KMS. The default is KMSDigestModeAuto.
+func WithDigestMode(mode KMSDigestMode) KMSSignerOption {
+	return func(s *KMSSigner) {
+		s.digestMode = mode
+	}
+}
+
+const VerifyKMSSignaturesEnvVar = "VERIFY_KMS_SIGNATURES"
+
+// ConfiguredKMSSignatureVerification reports whether the stack is configured
+// to verify signatures returned by KMS.
+func ConfiguredKMSSignatureVerification() bool {
+	return os.Getenv(VerifyKMSSignaturesEnvVar) == "true"
+}
+
+// WithSignatureVerification verifies every signature returned by KMS against
+// the cached public key before it is used in a token.
+func WithSignatureVerification(verify bool) KMSSignerOption {
+	return func(s *KMSSigner) {
+		s.verifySignatures = verify
+	}
+}
+
+// LoadKMSPublicKey returns the public key for a KMS key.
+func LoadKMSPublicKey(ctx context.Context, client KMSAPI, keyArn string) (crypto.PublicKey, error) {
+	publicKeyOutput, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{
+		KeyId: lo.ToPtr(keyArn),
+	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(publicKeyOutput.PublicKey)
+}
+
+// LoadKMSSigningKey returns the public key for a KMS key, after checking that
+// the key can sign with the algorithm.
+func LoadKMSSigningKey(ctx context.Context, client KMSAPI, alg SigningAlgorithm, keyArn string) (crypto.PublicKey, error) {
+	publicKeyOutput, err := client.GetPublicKey(ctx, &kms.GetPublicKeyInput{
+		KeyId: lo.ToPtr(keyArn),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CheckKMSKey(alg, publicKeyOutput); err != nil {
+		return nil, err
+	}
+
+	return x509.ParsePKIXPublicKey(publicKeyOutput.PublicKey)
+}
+
+// CheckKMSKey returns an error if the KMS key described by the GetPublicKey
+// response can't sign with the algorithm.
+func CheckKMSKey(alg SigningAlgorithm, publicKeyOutput *kms.GetPublicKeyOutput) error {
+	keyArn := lo.FromPtr(publicKeyOutput.KeyId)
+
+	if publicKeyOutput.KeyUsage != kmstypes.KeyUsageTypeSignVerify {
+		return fmt.Errorf("issuer: KMS key %s has key usage %q, expected %q", keyArn, publicKeyOutput.KeyUsage, kmstypes.KeyUsageTypeSignVerify)
+	}
+
+	if alg.IsRSA() {
+		if _, ok := rsaKeySpecBits[publicKeyOutput.KeySpec]; !ok {
+			return fmt.Errorf("issuer: KMS key %s has key spec %q, expected an RSA key spec for %s", keyArn, publicKeyOutput.KeySpec, alg.Name())
+		}
+	} else if publicKeyOutput.KeySpec != alg.KMSKeySpec {
+		return fmt.Errorf("issuer: KMS key %s has key spec %q, expected %q for %s", keyArn, publicKeyOutput.KeySpec, alg.KMSKeySpec, alg.Name())
+	}
+
+	if !slices.Contains(publicKeyOutput.SigningAlgorithms, alg.KMSSigningAlgorithm) {
+		return fmt.Errorf("issuer: KMS key %s doesn't support the %q signing algorithm", keyArn, alg.KMSSigningAlgorithm)
+	}
+
+	return nil
+}
+
+// NewKMSSigner returns a signer that calls KMS for every signature. It returns
+// an error if the KMS key cannot be used with the algorithm.
+func NewKMSSigner(client KMSAPI, alg SigningAlgorithm, keyArn string, keyID string, publicKey crypto.PublicKey, opts ...KMSSignerOption) (*KMSSigner, error) {
+	if !alg.SupportsKMS() {
+		return nil, fmt.Errorf("issuer: %s signing is not supported by KMS, use the ParameterStore key custodian instead", alg.Name())
 	}
 
+	if err := alg.CheckPublicKey(publicKey); err != nil {
+		return nil, err
+	}
+
+	signer := &KMSSigner{
+		alg:       alg,
+		client:    client,
+		keyArn:    keyArn,
+		keyID:     keyID,
+		publicKey: publicKey,
+	}
+	for _, opt := range opts {
+		opt(signer)
+	}
+
+	return signer, nil
+}
+
+func (s *KMSSigner) Sign(ctx context.Context, signingString string) ([]byte, error) {
+	defer LogWithTiming(ctx, slog.LevelDebug, "issuer.KMSSigner.Sign", "kmsKeyArn", s.keyArn)()
+
 	signInput := &kms.SignInput{
-		KeyId:            lo.ToPtr(kmsKeyArn),
-		Message:          []byte(sstr),
+		KeyId:            lo.ToPtr(s.keyArn),
+		Message:          []byte(signingString),
 		MessageType:      kmstypes.MessageTypeRaw,
-		SigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha256,
+		SigningAlgorithm: s.alg.KMSSigningAlgorithm,
+	}
+
+	// KMS rejects raw messages over 4096 bytes. A digest made with the
+	// algorithm's hash produces the same signature, so large claim sets sign
+	// the same way they do with Parameter Store keys.
+	if s.digestMode == KMSDigestModeAlways || len(signInput.Message) > kmsMaxRawMessageSize {
+		hash := s.alg.Hash().New()
+		hash.Write(signInput.Message)
+		signInput.Message = hash.Sum(nil)
+		signInput.MessageType = kmstypes.MessageTypeDigest
 	}
 
-	signOutput, err := kmsClient.Sign(ctx, signInput)
+	signOutput, err := s.signWithFailover(ctx, signInput)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	// KMS returns a DER-encoded object as defined by ANS X9.62–2005
-	// and RFC 3279 Section 2.2.3 (https://tools.ietf.org/html/rfc3279#section-2.2.3).
+	signature := signOutput.Signature
+
+	// For ECDSA keys, KMS returns a DER-encoded object as defined by ANS
+	// X9.62–2005 and RFC 3279 Section 2.2.3
+	// (https://tools.ietf.org/html/rfc3279#section-2.2.3).
 	//
 	// We need to convert it to the JWT r || s format before applying
-	// it as the signature.
+	// it as the signature. RSA signatures are used as-is.
 	// https://stackoverflow.com/questions/66170120/aws-kms-signature-returns-invalid-signature-for-my-jwt
 	// https://stackoverflow.com/questions/48423188/verifying-a-ecdsa-signature-with-a-provided-public-key
+	if s.alg.IsECDSA() {
+		signature, err = derToJOSE(signature, s.alg.signatureSize())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.verifySignatures {
+		if err := s.alg.Method.Verify(signingString, signature, s.publicKey); err != nil {
+			return nil, fmt.Errorf("issuer: KMS signature failed verification: %w", err)
+		}
+	}
+
+	return signature, nil
+}
+
+func (s *KMSSigner) Algorithm() string {
+	return s.alg.Name()
+}
+
+func (s *KMSSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *KMSSigner) PublicKey() crypto.PublicKey {
+	return s.publicKey
+}
+
+// derToJOSE converts a DER-encoded ECDSA signature into the fixed-width r || s
+// form required by RFC 7518 Section 3.4, left-padding each integer to size
+// bytes.
+func derToJOSE(der []byte, size int) ([]byte, error) {
 	var esig struct {
 		R, S *big.Int
 	}
-	asn1.Unmarshal(signOutput.Signature, &esig)
 
-	fullSignature := []byte{}
-	fullSignature = append(fullSignature, esig.R.Bytes()...)
-	fullSignature = append(fullSignature, esig.S.Bytes()...)
+	rest, err := asn1.Unmarshal(der, &esig)
+	if err != nil {
+		return nil, &DERSignatureError{Reason: "invalid ASN.1", Err: err}
+	}
+	if len(rest) > 0 {
+		return nil, &DERSignatureError{Reason: "trailing data after signature"}
+	}
+	if esig.R.Sign() <= 0 || esig.S.Sign() <= 0 {
+		return nil, &DERSignatureError{Reason: "r and s must be positive"}
+	}
+	if esig.R.BitLen() > size*8 || esig.S.BitLen() > size*8 {
+		return nil, &DERSignatureError{Reason: fmt.Sprintf("r and s must fit in %d bytes", size)}
+	}
 
-	sig := base64.RawURLEncoding.EncodeToString(fullSignature)
+	signature := make([]byte, 2*size)
+	esig.R.FillBytes(signature[:size])
+	esig.S.FillBytes(signature[size:])
 
-	return strings.Join([]string{sstr, sig}, "."), nil
+	return signature, nil
 }
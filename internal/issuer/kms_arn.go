@@ -0,0 +1,118 @@
+package issuer
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/samber/lo"
+)
+
+var (
+	kmsKeyIDPattern     = regexp.MustCompile(`^(?:[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|mrk-[0-9a-fA-F]{32})$`)
+	kmsAliasNamePattern = regexp.MustCompile(`^alias/[a-zA-Z0-9/_-]+$`)
+)
+
+// KMSKeyArn is a parsed KMS key ARN or alias ARN.
+type KMSKeyArn struct {
+	arn.ARN
+
+	// The key ID for key ARNs, such as
+	// "1234abcd-12ab-34cd-56ef-1234567890ab", or
+	// "mrk-1234abcd12ab34cd56ef1234567890ab" for multi-Region keys.
+	KeyID string
+
+	// The alias name for alias ARNs, such as "alias/jwt-issuer".
+	AliasName string
+}
+
+// ParseKMSKeyArn parses a KMS key ARN or alias ARN, returning an error for
+// anything else.
+func ParseKMSKeyArn(value string) (KMSKeyArn, error) {
+	parsed, err := arn.Parse(value)
+	if err != nil {
+		return KMSKeyArn{}, fmt.Errorf("issuer: malformed KMS key ARN %q: %w", value, err)
+	}
+
+	if parsed.Service != "kms" || parsed.Region == "" || parsed.AccountID == "" {
+		return KMSKeyArn{}, fmt.Errorf("issuer: malformed KMS key ARN %q: expected a KMS key or alias ARN with a region and account", value)
+	}
+
+	keyArn := KMSKeyArn{ARN: parsed}
+	switch {
+	case strings.HasPrefix(parsed.Resource, "key/"):
+		keyArn.KeyID = strings.TrimPrefix(parsed.Resource, "key/")
+		if !kmsKeyIDPattern.MatchString(keyArn.KeyID) {
+			return KMSKeyArn{}, fmt.Errorf("issuer: malformed KMS key ARN %q: %q is not a KMS key ID", value, keyArn.KeyID)
+		}
+	case strings.HasPrefix(parsed.Resource, "alias/"):
+		keyArn.AliasName = parsed.Resource
+		if !kmsAliasNamePattern.MatchString(keyArn.AliasName) {
+			return KMSKeyArn{}, fmt.Errorf("issuer: malformed KMS key ARN %q: %q is not a KMS alias name", value, keyArn.AliasName)
+		}
+	default:
+		return KMSKeyArn{}, fmt.Errorf("issuer: malformed KMS key ARN %q: resource must start with \"key/\" or \"alias/\"", value)
+	}
+
+	return keyArn, nil
+}
+
+// IsAlias reports whether the ARN names an alias rather than a key.
+func (a KMSKeyArn) IsAlias() bool {
+	return a.AliasName != ""
+}
+
+// IsMultiRegion reports whether the ARN names a multi-Region key.
+func (a KMSKeyArn) IsMultiRegion() bool {
+	return strings.HasPrefix(a.KeyID, "mrk-")
+}
+
+// ResolveKMSKeyArn parses a KMS key ARN or alias ARN and returns the ARN of
+// the key. Aliases are resolved to the key they currently point to with
+// DescribeKey, so tokens are signed by, and their kid derived from, a single
+// key even if the alias is later moved.
+func ResolveKMSKeyArn(ctx context.Context, client KMSAPI, value string) (KMSKeyArn, error) {
+	keyArn, err := ParseKMSKeyArn(value)
+	if err != nil || !keyArn.IsAlias() {
+		return keyArn, err
+	}
+
+	describeKeyOutput, err := client.DescribeKey(ctx, &kms.DescribeKeyInput{
+		KeyId: lo.ToPtr(value),
+	})
+	if err != nil {
+		return KMSKeyArn{}, err
+	}
+
+	if describeKeyOutput.KeyMetadata == nil {
+		return KMSKeyArn{}, fmt.Errorf("issuer: KMS alias %q didn't resolve to a key", value)
+	}
+
+	resolved, err := ParseKMSKeyArn(lo.FromPtr(describeKeyOutput.KeyMetadata.Arn))
+	if err != nil {
+		return KMSKeyArn{}, fmt.Errorf("issuer: KMS alias %q didn't resolve to a key: %w", value, err)
+	}
+	if resolved.IsAlias() {
+		return KMSKeyArn{}, fmt.Errorf("issuer: KMS alias %q resolved to another alias %q", value, resolved)
+	}
+
+	return resolved, nil
+}
+
+// KMSKeyResourceID returns the key ID portion of a KMS key ARN. Alias ARNs
+// must be resolved with ResolveKMSKeyArn first.
+func KMSKeyResourceID(keyArn string) (string, error) {
+	parsed, err := ParseKMSKeyArn(keyArn)
+	if err != nil {
+		return "", err
+	}
+
+	if parsed.IsAlias() {
+		return "", fmt.Errorf("issuer: KMS key ARN %q is an alias, which doesn't identify a key", keyArn)
+	}
+
+	return parsed.KeyID, nil
+}
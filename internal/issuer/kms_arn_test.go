@@ -0,0 +1,114 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseKMSKeyArn(t *testing.T) {
+	t.Run("key ARNs", func(t *testing.T) {
+		keyArn, err := ParseKMSKeyArn("arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b")
+		require.NoError(t, err)
+		assert.Equal(t, "4a2c1b37-e4c8-466a-b873-11aaf144b01b", keyArn.KeyID)
+		assert.Equal(t, "us-east-1", keyArn.Region)
+		assert.False(t, keyArn.IsAlias())
+		assert.False(t, keyArn.IsMultiRegion())
+		assert.Equal(t, "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b", keyArn.String())
+	})
+
+	t.Run("multi-Region key ARNs", func(t *testing.T) {
+		keyArn, err := ParseKMSKeyArn("arn:aws:kms:eu-west-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab")
+		require.NoError(t, err)
+		assert.Equal(t, "mrk-1234abcd12ab34cd56ef1234567890ab", keyArn.KeyID)
+		assert.True(t, keyArn.IsMultiRegion())
+	})
+
+	t.Run("alias ARNs", func(t *testing.T) {
+		keyArn, err := ParseKMSKeyArn("arn:aws-us-gov:kms:us-gov-west-1:111111111111:alias/jwt-issuer/signing")
+		require.NoError(t, err)
+		assert.Equal(t, "alias/jwt-issuer/signing", keyArn.AliasName)
+		assert.Empty(t, keyArn.KeyID)
+		assert.True(t, keyArn.IsAlias())
+	})
+
+	for _, value := range []string{
+		"",
+		"not-an-arn",
+		"4a2c1b37-e4c8-466a-b873-11aaf144b01b",
+		"arn:aws:kms:us-east-1:111111111111:key/",
+		"arn:aws:kms:us-east-1:111111111111:key/4a2c1b37",
+		"arn:aws:kms:us-east-1:111111111111:key/mrk-1234",
+		"arn:aws:kms:us-east-1:111111111111:alias/",
+		"arn:aws:kms:us-east-1:111111111111:alias/has spaces",
+		"arn:aws:kms:us-east-1:111111111111:grant/4a2c1b37-e4c8-466a-b873-11aaf144b01b",
+		"arn:aws:kms::111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b",
+		"arn:aws:ssm:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b",
+	} {
+		_, err := ParseKMSKeyArn(value)
+		assert.ErrorContains(t, err, "issuer: malformed KMS key ARN", value)
+	}
+}
+
+func Test_ResolveKMSKeyArn(t *testing.T) {
+	const keyArn = "arn:aws:kms:us-east-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+	const aliasArn = "arn:aws:kms:us-east-1:111111111111:alias/jwt-issuer"
+
+	t.Run("returns key ARNs without calling KMS", func(t *testing.T) {
+		mockKMS := mocks.KMSAPI{}
+		resolved, err := ResolveKMSKeyArn(context.Background(), &mockKMS, keyArn)
+		require.NoError(t, err)
+		assert.Equal(t, keyArn, resolved.String())
+		mockKMS.AssertNotCalled(t, "DescribeKey", mock.Anything, mock.Anything)
+	})
+
+	t.Run("resolves aliases", func(t *testing.T) {
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("DescribeKey", mock.Anything, &kms.DescribeKeyInput{KeyId: lo.ToPtr(aliasArn)}).Return(&kms.DescribeKeyOutput{
+			KeyMetadata: &kmstypes.KeyMetadata{Arn: lo.ToPtr(keyArn)},
+		}, nil)
+
+		resolved, err := ResolveKMSKeyArn(context.Background(), &mockKMS, aliasArn)
+		require.NoError(t, err)
+		assert.Equal(t, keyArn, resolved.String())
+		assert.True(t, resolved.IsMultiRegion())
+	})
+
+	t.Run("returns DescribeKey errors", func(t *testing.T) {
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("DescribeKey", mock.Anything, mock.Anything).Return(nil, errors.New("NotFoundException"))
+
+		_, err := ResolveKMSKeyArn(context.Background(), &mockKMS, aliasArn)
+		assert.EqualError(t, err, "NotFoundException")
+	})
+
+	t.Run("rejects malformed ARNs without calling KMS", func(t *testing.T) {
+		mockKMS := mocks.KMSAPI{}
+		_, err := ResolveKMSKeyArn(context.Background(), &mockKMS, "alias/jwt-issuer")
+		assert.ErrorContains(t, err, "malformed KMS key ARN")
+	})
+}
+
+func Test_KMSKeyResourceID(t *testing.T) {
+	resourceID, err := KMSKeyResourceID("arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b")
+	require.NoError(t, err)
+	assert.Equal(t, "4a2c1b37-e4c8-466a-b873-11aaf144b01b", resourceID)
+
+	resourceID, err = KMSKeyResourceID("arn:aws:kms:us-east-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab")
+	require.NoError(t, err)
+	assert.Equal(t, "mrk-1234abcd12ab34cd56ef1234567890ab", resourceID)
+
+	_, err = KMSKeyResourceID("not-an-arn")
+	assert.ErrorContains(t, err, "malformed KMS key ARN")
+
+	_, err = KMSKeyResourceID("arn:aws:kms:us-east-1:111111111111:alias/jwt-issuer")
+	assert.EqualError(t, err, `issuer: KMS key ARN "arn:aws:kms:us-east-1:111111111111:alias/jwt-issuer" is an alias, which doesn't identify a key`)
+}
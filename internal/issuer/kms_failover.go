@@ -0,0 +1,289 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/smithy-go"
+)
+
+const SigningKeyReplicaArnsEnvVar = "SIGNING_KEY_REPLICA_ARNS"
+
+// ConfiguredReplicaKeyArns returns the ARNs of the replicas of the key created
+// with the stack, in the order they're failed over to.
+func ConfiguredReplicaKeyArns() []string {
+	var keyArns []string
+	for _, keyArn := range strings.Split(os.Getenv(SigningKeyReplicaArnsEnvVar), ",") {
+		if keyArn = strings.TrimSpace(keyArn); keyArn != "" {
+			keyArns = append(keyArns, keyArn)
+		}
+	}
+
+	return keyArns
+}
+
+// CheckReplicaKeyArns parses the ARNs of replicas of a multi-Region key,
+// returning an error unless each is a key ARN for the same key in a region
+// that doesn't already have one.
+func CheckReplicaKeyArns(primary KMSKeyArn, replicaKeyArns []string) ([]KMSKeyArn, error) {
+	if len(replicaKeyArns) == 0 {
+		return nil, nil
+	}
+
+	if !primary.IsMultiRegion() {
+		return nil, fmt.Errorf("issuer: KMS key %s isn't a multi-Region key, so it can't have replicas", primary)
+	}
+
+	regions := map[string]bool{primary.Region: true}
+	replicas := make([]KMSKeyArn, 0, len(replicaKeyArns))
+	for _, value := range replicaKeyArns {
+		replica, err := ParseKMSKeyArn(value)
+		if err != nil {
+			return nil, err
+		}
+		if replica.IsAlias() {
+			return nil, fmt.Errorf("issuer: replica KMS key ARN %q is an alias, replicas must be key ARNs", value)
+		}
+		if replica.KeyID != primary.KeyID || replica.Partition != primary.Partition || replica.AccountID != primary.AccountID {
+			return nil, fmt.Errorf("issuer: KMS key %s isn't a replica of %s", value, primary)
+		}
+		if regions[replica.Region] {
+			return nil, fmt.Errorf("issuer: replica KMS key %s is in region %s, which already has a replica", value, replica.Region)
+		}
+		regions[replica.Region] = true
+		replicas = append(replicas, replica)
+	}
+
+	return replicas, nil
+}
+
+// KMSReplica is a replica of a multi-Region key and the client for its region.
+type KMSReplica struct {
+	KeyArn string
+	Client KMSAPI
+}
+
+// kmsEndpoint is a key that a KMSSigner can sign with.
+type kmsEndpoint struct {
+	keyArn string
+	region string
+	client KMSAPI
+}
+
+// WithReplicas signs with the replicas, in order, when KMS in the region of
+// the signer's key is throttling or failing. Replicas of a multi-Region key
+// share its key material, so tokens signed by them have the same kid and
+// verify with the same public key. Each region's failures are counted by the
+// breaker, which skips regions that keep failing.
+func WithReplicas(breaker *CircuitBreaker, replicas ...KMSReplica) KMSSignerOption {
+	return func(s *KMSSigner) {
+		s.breaker = breaker
+		for _, replica := range replicas {
+			s.replicas = append(s.replicas, kmsEndpoint{
+				keyArn: replica.KeyArn,
+				region: kmsKeyArnRegion(replica.KeyArn),
+				client: replica.Client,
+			})
+		}
+	}
+}
+
+// signWithFailover calls Sign in the region of the signer's key, then in the
+// region of each replica in turn while the error is one that another region
+// might not have. Regions with an open circuit are skipped, unless every
+// region's circuit is open.
+func (s *KMSSigner) signWithFailover(ctx context.Context, signInput *kms.SignInput) (*kms.SignOutput, error) {
+	if len(s.replicas) == 0 {
+		return s.client.Sign(ctx, signInput)
+	}
+
+	endpoints := []kmsEndpoint{{keyArn: s.keyArn, region: kmsKeyArnRegion(s.keyArn), client: s.client}}
+	endpoints = append(endpoints, s.replicas...)
+
+	available := make([]kmsEndpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if s.breaker.Allow(endpoint.region) {
+			available = append(available, endpoint)
+		} else {
+			slog.DebugContext(ctx, "issuer.KMSSigner: skipping region with open circuit", "region", endpoint.region)
+		}
+	}
+	if len(available) == 0 {
+		available = endpoints
+	}
+
+	var err error
+	for i, endpoint := range available {
+		input := *signInput
+		input.KeyId = &endpoint.keyArn
+
+		// The SDK retries throttling and server errors in the same region. With
+		// another region to fail over to, trying it is faster.
+		var optFns []func(*kms.Options)
+		if i < len(available)-1 {
+			optFns = append(optFns, func(o *kms.Options) { o.RetryMaxAttempts = 1 })
+		}
+
+		var signOutput *kms.SignOutput
+		signOutput, err = endpoint.client.Sign(ctx, &input, optFns...)
+		if err == nil {
+			if s.breaker.Success(endpoint.region) {
+				slog.InfoContext(ctx, "issuer.KMSSigner: circuit closed", "region", endpoint.region)
+			}
+			return signOutput, nil
+		}
+
+		if !isKMSFailoverError(ctx, err) {
+			return nil, err
+		}
+
+		if s.breaker.Failure(endpoint.region) {
+			slog.WarnContext(ctx, "issuer.KMSSigner: circuit opened", "region", endpoint.region, "error", err)
+		}
+
+		if i < len(available)-1 {
+			next := available[i+1]
+			slog.WarnContext(ctx, "issuer.KMSSigner: failing over", "fromKmsKeyArn", endpoint.keyArn, "toKmsKeyArn", next.keyArn, "error", err)
+			LogMetric(ctx, slog.LevelWarn, "KMSSignFailovers", 1)
+		}
+	}
+
+	return nil, err
+}
+
+// isKMSFailoverError reports whether err is a throttling error, a server
+// error, or a failure to reach KMS, which KMS in another region might not
+// return.
+func isKMSFailoverError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && isThrottleErrorCode(apiErr.ErrorCode()) {
+		return true
+	}
+
+	return isServerOrNetworkError(err)
+}
+
+func kmsKeyArnRegion(keyArn string) string {
+	parsed, err := ParseKMSKeyArn(keyArn)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Region
+}
+
+// CircuitBreaker tracks consecutive KMS failures in each region for the life
+// of an execution environment. After Threshold failures in a row a region's
+// circuit opens and the region is skipped for Cooldown. Once that passes,
+// the next request tries the region again, closing the circuit if it
+// succeeds and reopening it if it fails.
+type CircuitBreaker struct {
+	// Defaults to 3 failures and 30 seconds.
+	Threshold int
+	Cooldown  time.Duration
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time
+}
+
+// Allow reports whether requests should be sent to the region. A nil breaker
+// allows every region.
+func (b *CircuitBreaker) Allow(region string) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[region]
+	return !ok || c.failures < b.threshold() || !b.now().Before(c.openUntil)
+}
+
+// Success resets the region's failures, reporting whether its circuit was
+// open.
+func (b *CircuitBreaker) Success(region string) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c, ok := b.circuits[region]
+	if !ok {
+		return false
+	}
+	delete(b.circuits, region)
+
+	return c.failures >= b.threshold()
+}
+
+// Failure counts a failure in the region, reporting whether it opened the
+// region's circuit.
+func (b *CircuitBreaker) Failure(region string) bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.circuits == nil {
+		b.circuits = map[string]*circuit{}
+	}
+	c, ok := b.circuits[region]
+	if !ok {
+		c = &circuit{}
+		b.circuits[region] = c
+	}
+
+	wasOpen := c.failures >= b.threshold()
+	c.failures++
+	if c.failures < b.threshold() {
+		return false
+	}
+	c.openUntil = b.now().Add(b.cooldown())
+
+	return !wasOpen
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold <= 0 {
+		return 3
+	}
+	return b.Threshold
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return 30 * time.Second
+	}
+	return b.Cooldown
+}
+
+func (b *CircuitBreaker) now() time.Time {
+	if b.Now != nil {
+		return b.Now()
+	}
+	return time.Now()
+}
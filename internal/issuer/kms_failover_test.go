@@ -0,0 +1,314 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	primaryMRKArn = "arn:aws:kms:us-east-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+	westMRKArn    = "arn:aws:kms:us-west-2:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+	euMRKArn      = "arn:aws:kms:eu-west-1:111111111111:key/mrk-1234abcd12ab34cd56ef1234567890ab"
+)
+
+func Test_ConfiguredReplicaKeyArns(t *testing.T) {
+	t.Setenv(SigningKeyReplicaArnsEnvVar, "")
+	assert.Empty(t, ConfiguredReplicaKeyArns())
+
+	t.Setenv(SigningKeyReplicaArnsEnvVar, westMRKArn+", "+euMRKArn+",")
+	assert.Equal(t, []string{westMRKArn, euMRKArn}, ConfiguredReplicaKeyArns())
+}
+
+func Test_CheckReplicaKeyArns(t *testing.T) {
+	primary, err := ParseKMSKeyArn(primaryMRKArn)
+	require.NoError(t, err)
+
+	t.Run("parses replicas in order", func(t *testing.T) {
+		replicas, err := CheckReplicaKeyArns(primary, []string{westMRKArn, euMRKArn})
+		require.NoError(t, err)
+		require.Len(t, replicas, 2)
+		assert.Equal(t, "us-west-2", replicas[0].Region)
+		assert.Equal(t, "eu-west-1", replicas[1].Region)
+	})
+
+	t.Run("allows no replicas for any key", func(t *testing.T) {
+		singleRegion, err := ParseKMSKeyArn("arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b")
+		require.NoError(t, err)
+
+		replicas, err := CheckReplicaKeyArns(singleRegion, nil)
+		require.NoError(t, err)
+		assert.Empty(t, replicas)
+	})
+
+	for name, tc := range map[string]struct {
+		primary  string
+		replicas []string
+		err      string
+	}{
+		"single-Region primary": {
+			primary:  "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b",
+			replicas: []string{westMRKArn},
+			err:      "isn't a multi-Region key",
+		},
+		"malformed replica": {
+			primary:  primaryMRKArn,
+			replicas: []string{"mrk-1234abcd12ab34cd56ef1234567890ab"},
+			err:      "malformed KMS key ARN",
+		},
+		"alias replica": {
+			primary:  primaryMRKArn,
+			replicas: []string{"arn:aws:kms:us-west-2:111111111111:alias/jwt-issuer"},
+			err:      "is an alias",
+		},
+		"different key": {
+			primary:  primaryMRKArn,
+			replicas: []string{"arn:aws:kms:us-west-2:111111111111:key/mrk-00000000000000000000000000000000"},
+			err:      "isn't a replica of",
+		},
+		"different account": {
+			primary:  primaryMRKArn,
+			replicas: []string{"arn:aws:kms:us-west-2:222222222222:key/mrk-1234abcd12ab34cd56ef1234567890ab"},
+			err:      "isn't a replica of",
+		},
+		"primary region": {
+			primary:  primaryMRKArn,
+			replicas: []string{primaryMRKArn},
+			err:      "already has a replica",
+		},
+		"duplicate region": {
+			primary:  primaryMRKArn,
+			replicas: []string{westMRKArn, westMRKArn},
+			err:      "already has a replica",
+		},
+	} {
+		t.Run("rejects "+name, func(t *testing.T) {
+			primary, err := ParseKMSKeyArn(tc.primary)
+			require.NoError(t, err)
+
+			_, err = CheckReplicaKeyArns(primary, tc.replicas)
+			require.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func Test_CircuitBreaker(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newBreaker := func() *CircuitBreaker {
+		return &CircuitBreaker{Threshold: 2, Cooldown: time.Minute, Now: func() time.Time { return now }}
+	}
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		breaker := newBreaker()
+		assert.True(t, breaker.Allow("us-east-1"))
+		assert.False(t, breaker.Failure("us-east-1"))
+		assert.True(t, breaker.Allow("us-east-1"))
+		assert.True(t, breaker.Failure("us-east-1"))
+		assert.False(t, breaker.Allow("us-east-1"))
+		assert.True(t, breaker.Allow("us-west-2"))
+	})
+
+	t.Run("resets failures after a success", func(t *testing.T) {
+		breaker := newBreaker()
+		breaker.Failure("us-east-1")
+		assert.False(t, breaker.Success("us-east-1"))
+		assert.False(t, breaker.Failure("us-east-1"))
+		assert.True(t, breaker.Allow("us-east-1"))
+	})
+
+	t.Run("tries the region again after the cooldown", func(t *testing.T) {
+		breaker := newBreaker()
+		breaker.Failure("us-east-1")
+		breaker.Failure("us-east-1")
+
+		breaker.Now = func() time.Time { return now.Add(time.Minute) }
+		assert.True(t, breaker.Allow("us-east-1"))
+
+		assert.False(t, breaker.Failure("us-east-1"), "already open")
+		assert.False(t, breaker.Allow("us-east-1"))
+
+		breaker.Now = func() time.Time { return now.Add(2 * time.Minute) }
+		assert.True(t, breaker.Allow("us-east-1"))
+		assert.True(t, breaker.Success("us-east-1"))
+		assert.True(t, breaker.Allow("us-east-1"))
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		breaker := &CircuitBreaker{}
+		assert.False(t, breaker.Failure("us-east-1"))
+		assert.False(t, breaker.Failure("us-east-1"))
+		assert.True(t, breaker.Failure("us-east-1"))
+		assert.False(t, breaker.Allow("us-east-1"))
+	})
+
+	t.Run("nil allows every region", func(t *testing.T) {
+		var breaker *CircuitBreaker
+		assert.True(t, breaker.Allow("us-east-1"))
+		assert.False(t, breaker.Failure("us-east-1"))
+		assert.False(t, breaker.Success("us-east-1"))
+	})
+}
+
+func Test_KMSSignerFailover(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	serverError := &smithy.OperationError{
+		ServiceID:     "KMS",
+		OperationName: "Sign",
+		Err: &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+			Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusServiceUnavailable}},
+			Err:      &kmstypes.KMSInternalException{Message: lo.ToPtr("internal error")},
+		}},
+	}
+	unreachable := &smithyhttp.RequestSendError{Err: errors.New("dial tcp: i/o timeout")}
+
+	signs := func(_ context.Context, input *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+		hash := alg.Hash().New()
+		hash.Write(input.Message)
+		signature, err := privateKey.Sign(rand.Reader, hash.Sum(nil), crypto.SHA256)
+		return &kms.SignOutput{Signature: signature}, err
+	}
+	fails := func(err error) func(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error) {
+		return func(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error) {
+			return nil, err
+		}
+	}
+
+	// Sign is called with a retry option for every region but the last.
+	newMock := func(sign func(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error)) *mocks.KMSAPI {
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("Sign", mock.Anything, mock.Anything).Return(sign).Maybe()
+		mockKMS.On("Sign", mock.Anything, mock.Anything, mock.Anything).Return(sign).Maybe()
+		return &mockKMS
+	}
+
+	newSigner := func(breaker *CircuitBreaker, primary *mocks.KMSAPI, replicas ...*mocks.KMSAPI) *KMSSigner {
+		replicaArns := []string{westMRKArn, euMRKArn}
+		kmsReplicas := lo.Map(replicas, func(client *mocks.KMSAPI, i int) KMSReplica {
+			return KMSReplica{KeyArn: replicaArns[i], Client: client}
+		})
+
+		signer, err := NewKMSSigner(primary, alg, primaryMRKArn, "mrk-1234abcd12ab34cd56ef1234567890ab", privateKey.Public(),
+			WithSignatureVerification(true), WithReplicas(breaker, kmsReplicas...))
+		require.NoError(t, err)
+		return signer
+	}
+
+	signInputKeyArns := func(client *mocks.KMSAPI) []string {
+		return lo.Map(client.Calls, func(call mock.Call, _ int) string {
+			return lo.FromPtr(call.Arguments.Get(1).(*kms.SignInput).KeyId)
+		})
+	}
+
+	for name, err := range map[string]error{"throttling": throttled, "server errors": serverError, "unreachable KMS": unreachable} {
+		t.Run("fails over to a replica on "+name, func(t *testing.T) {
+			primary, replica := newMock(fails(err)), newMock(signs)
+			signer := newSigner(&CircuitBreaker{}, primary, replica)
+
+			signature, err := signer.Sign(context.Background(), "header.payload")
+			require.NoError(t, err)
+			require.NoError(t, alg.Method.Verify("header.payload", signature, privateKey.Public()))
+
+			assert.Equal(t, "mrk-1234abcd12ab34cd56ef1234567890ab", signer.KeyID())
+			assert.Equal(t, []string{primaryMRKArn}, signInputKeyArns(primary))
+			assert.Equal(t, []string{westMRKArn}, signInputKeyArns(replica))
+			assert.Len(t, primary.Calls[0].Arguments, 3, "primary doesn't retry in its region")
+			assert.Len(t, replica.Calls[0].Arguments, 2, "last region retries")
+		})
+	}
+
+	t.Run("fails over through replicas in order", func(t *testing.T) {
+		primary, west, eu := newMock(fails(throttled)), newMock(fails(serverError)), newMock(signs)
+		signer := newSigner(&CircuitBreaker{}, primary, west, eu)
+
+		_, err := signer.Sign(context.Background(), "header.payload")
+		require.NoError(t, err)
+		assert.Equal(t, []string{westMRKArn}, signInputKeyArns(west))
+		assert.Equal(t, []string{euMRKArn}, signInputKeyArns(eu))
+	})
+
+	t.Run("returns the last error when every region fails", func(t *testing.T) {
+		signer := newSigner(&CircuitBreaker{}, newMock(fails(throttled)), newMock(fails(serverError)))
+
+		_, err := signer.Sign(context.Background(), "header.payload")
+		require.ErrorIs(t, err, serverError)
+	})
+
+	t.Run("doesn't fail over on other errors", func(t *testing.T) {
+		disabled := &kmstypes.DisabledException{Message: lo.ToPtr("key is disabled")}
+		replica := newMock(signs)
+		signer := newSigner(&CircuitBreaker{}, newMock(fails(disabled)), replica)
+
+		_, err := signer.Sign(context.Background(), "header.payload")
+		require.ErrorIs(t, err, disabled)
+		assert.Empty(t, replica.Calls)
+	})
+
+	t.Run("doesn't fail over once the context is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		replica := newMock(signs)
+		signer := newSigner(&CircuitBreaker{}, newMock(fails(unreachable)), replica)
+
+		_, err := signer.Sign(ctx, "header.payload")
+		require.ErrorIs(t, err, unreachable)
+		assert.Empty(t, replica.Calls)
+	})
+
+	t.Run("skips a region with an open circuit", func(t *testing.T) {
+		breaker := &CircuitBreaker{Threshold: 2}
+		primary, replica := newMock(fails(throttled)), newMock(signs)
+		signer := newSigner(breaker, primary, replica)
+
+		for range 5 {
+			_, err := signer.Sign(context.Background(), "header.payload")
+			require.NoError(t, err)
+		}
+		assert.Len(t, primary.Calls, 2)
+		assert.Len(t, replica.Calls, 5)
+		assert.Len(t, replica.Calls[4].Arguments, 2, "only region left retries")
+	})
+
+	t.Run("shares circuits between signers", func(t *testing.T) {
+		breaker := &CircuitBreaker{Threshold: 1}
+		_, err := newSigner(breaker, newMock(fails(throttled)), newMock(signs)).Sign(context.Background(), "header.payload")
+		require.NoError(t, err)
+
+		primary := newMock(signs)
+		_, err = newSigner(breaker, primary, newMock(signs)).Sign(context.Background(), "header.payload")
+		require.NoError(t, err)
+		assert.Empty(t, primary.Calls)
+	})
+
+	t.Run("tries every region when every circuit is open", func(t *testing.T) {
+		breaker := &CircuitBreaker{Threshold: 1}
+		breaker.Failure("us-east-1")
+		breaker.Failure("us-west-2")
+		primary := newMock(signs)
+		signer := newSigner(breaker, primary, newMock(signs))
+
+		_, err := signer.Sign(context.Background(), "header.payload")
+		require.NoError(t, err)
+		assert.Len(t, primary.Calls, 1)
+		assert.True(t, breaker.Allow("us-east-1"), "success closes the circuit")
+	})
+}
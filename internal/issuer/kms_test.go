@@ -0,0 +1,233 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_derToJOSE(t *testing.T) {
+	t.Run("left-pads short integers", func(t *testing.T) {
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{big.NewInt(1), big.NewInt(258)})
+		require.NoError(t, err)
+
+		signature, err := derToJOSE(der, 32)
+		require.NoError(t, err)
+		require.Len(t, signature, 64)
+		assert.Equal(t, byte(1), signature[31])
+		assert.Equal(t, []byte{1, 2}, signature[62:])
+	})
+
+	t.Run("rejects malformed DER", func(t *testing.T) {
+		_, err := derToJOSE([]byte{0x30, 0x01}, 32)
+		var derErr *DERSignatureError
+		require.ErrorAs(t, err, &derErr)
+	})
+
+	t.Run("rejects oversized integers", func(t *testing.T) {
+		tooBig := new(big.Int).Lsh(big.NewInt(1), 256)
+		der, err := asn1.Marshal(struct{ R, S *big.Int }{tooBig, big.NewInt(1)})
+		require.NoError(t, err)
+
+		_, err = derToJOSE(der, 32)
+		var derErr *DERSignatureError
+		require.ErrorAs(t, err, &derErr)
+	})
+}
+
+func Test_KMSSigner(t *testing.T) {
+	signWith := func(alg SigningAlgorithm, key crypto.Signer) func(context.Context, *kms.SignInput, ...func(*kms.Options)) (*kms.SignOutput, error) {
+		return func(ctx context.Context, input *kms.SignInput, _ ...func(*kms.Options)) (*kms.SignOutput, error) {
+			digest := input.Message
+			if input.MessageType == kmstypes.MessageTypeRaw {
+				if len(input.Message) > 4096 {
+					return nil, errors.New("ValidationException: message must be at most 4096 bytes")
+				}
+				hash := alg.Hash().New()
+				hash.Write(input.Message)
+				digest = hash.Sum(nil)
+			}
+
+			var opts crypto.SignerOpts = alg.Hash()
+			if input.SigningAlgorithm == kmstypes.SigningAlgorithmSpecRsassaPssSha256 {
+				opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: alg.Hash()}
+			}
+
+			signature, err := key.Sign(rand.Reader, digest, opts)
+			return &kms.SignOutput{Signature: signature}, err
+		}
+	}
+
+	newSigner := func(alg SigningAlgorithm, publicKey crypto.PublicKey, signingKey crypto.Signer, opts ...KMSSignerOption) *KMSSigner {
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("Sign", mock.Anything, mock.Anything).Return(signWith(alg, signingKey), nil)
+
+		signer, err := NewKMSSigner(&mockKMS, alg, "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37", "4a2c1b37", publicKey, opts...)
+		require.NoError(t, err)
+		return signer
+	}
+
+	for name, size := range map[string]int{"ES256": 64, "ES384": 96, "ES512": 132, "RS256": 256, "PS256": 256} {
+		t.Run(name+" signatures are always fixed-width and verify", func(t *testing.T) {
+			alg, err := LookupSigningAlgorithm(name)
+			require.NoError(t, err)
+			privateKey, err := alg.GenerateKey()
+			require.NoError(t, err)
+
+			signer := newSigner(alg, privateKey.Public(), privateKey)
+			assert.Equal(t, name, signer.Algorithm())
+			for range 64 {
+				signature, err := signer.Sign(context.Background(), "header.payload")
+				require.NoError(t, err)
+				require.Len(t, signature, size)
+				require.NoError(t, alg.Method.Verify("header.payload", signature, privateKey.Public()))
+			}
+		})
+	}
+
+	t.Run("hashes large signing strings locally", func(t *testing.T) {
+		for _, name := range []string{"ES256", "ES384", "RS256", "PS256"} {
+			alg, err := LookupSigningAlgorithm(name)
+			require.NoError(t, err)
+			privateKey, err := alg.GenerateKey()
+			require.NoError(t, err)
+
+			signer := newSigner(alg, privateKey.Public(), privateKey)
+			for _, size := range []int{4096, 4097, 64 * 1024} {
+				signingString := strings.Repeat("a", size)
+				signature, err := signer.Sign(context.Background(), signingString)
+				require.NoError(t, err, "%s with %d bytes", name, size)
+				require.NoError(t, alg.Method.Verify(signingString, signature, privateKey.Public()))
+			}
+
+			messageTypes := lo.Map(signer.client.(*mocks.KMSAPI).Calls, func(call mock.Call, _ int) kmstypes.MessageType {
+				return call.Arguments.Get(1).(*kms.SignInput).MessageType
+			})
+			assert.Equal(t, []kmstypes.MessageType{kmstypes.MessageTypeRaw, kmstypes.MessageTypeDigest, kmstypes.MessageTypeDigest}, messageTypes)
+		}
+	})
+
+	t.Run("always hashes signing strings locally in Always mode", func(t *testing.T) {
+		alg, err := LookupSigningAlgorithm("ES384")
+		require.NoError(t, err)
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+
+		signer := newSigner(alg, privateKey.Public(), privateKey, WithDigestMode(KMSDigestModeAlways))
+		signature, err := signer.Sign(context.Background(), "header.payload")
+		require.NoError(t, err)
+		require.NoError(t, alg.Method.Verify("header.payload", signature, privateKey.Public()))
+
+		signInput := signer.client.(*mocks.KMSAPI).Calls[0].Arguments.Get(1).(*kms.SignInput)
+		assert.Equal(t, kmstypes.MessageTypeDigest, signInput.MessageType)
+		assert.Len(t, signInput.Message, 48)
+	})
+
+	t.Run("verification rejects signatures from a different key", func(t *testing.T) {
+		alg, err := LookupSigningAlgorithm("ES256")
+		require.NoError(t, err)
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		signer := newSigner(alg, &privateKey.PublicKey, otherKey, WithSignatureVerification(true))
+		_, err = signer.Sign(context.Background(), "header.payload")
+		require.ErrorIs(t, err, jwt.ErrECDSAVerification)
+	})
+
+	t.Run("rejects a key that does not match the algorithm", func(t *testing.T) {
+		alg, err := LookupSigningAlgorithm("ES384")
+		require.NoError(t, err)
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		mockKMS := mocks.KMSAPI{}
+		_, err = NewKMSSigner(&mockKMS, alg, "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37", "4a2c1b37", &privateKey.PublicKey)
+		require.Error(t, err)
+	})
+
+	t.Run("rejects algorithms that KMS does not support", func(t *testing.T) {
+		alg, err := LookupSigningAlgorithm("EdDSA")
+		require.NoError(t, err)
+
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+
+		mockKMS := mocks.KMSAPI{}
+		_, err = NewKMSSigner(&mockKMS, alg, "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37", "4a2c1b37", privateKey.Public())
+		require.ErrorContains(t, err, "not supported by KMS")
+	})
+}
+
+func Test_ConfiguredKMSDigestMode(t *testing.T) {
+	mode, err := ConfiguredKMSDigestMode()
+	require.NoError(t, err)
+	assert.Equal(t, KMSDigestModeAuto, mode)
+
+	t.Setenv(KMSDigestModeEnvVar, "Always")
+	mode, err = ConfiguredKMSDigestMode()
+	require.NoError(t, err)
+	assert.Equal(t, KMSDigestModeAlways, mode)
+
+	t.Setenv(KMSDigestModeEnvVar, "Never")
+	_, err = ConfiguredKMSDigestMode()
+	assert.EqualError(t, err, `issuer: unsupported KMS digest mode "Never"`)
+}
+
+func Test_ConfiguredKMSSignatureVerification(t *testing.T) {
+	assert.False(t, ConfiguredKMSSignatureVerification())
+
+	t.Setenv(VerifyKMSSignaturesEnvVar, "true")
+	assert.True(t, ConfiguredKMSSignatureVerification())
+
+	t.Setenv(VerifyKMSSignaturesEnvVar, "false")
+	assert.False(t, ConfiguredKMSSignatureVerification())
+}
+
+func Test_CheckKMSKey(t *testing.T) {
+	es256, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+	rs256, err := LookupSigningAlgorithm("RS256")
+	require.NoError(t, err)
+
+	output := func(keySpec kmstypes.KeySpec, keyUsage kmstypes.KeyUsageType, signingAlgorithm kmstypes.SigningAlgorithmSpec) *kms.GetPublicKeyOutput {
+		return &kms.GetPublicKeyOutput{
+			KeySpec:           keySpec,
+			KeyUsage:          keyUsage,
+			SigningAlgorithms: []kmstypes.SigningAlgorithmSpec{signingAlgorithm},
+		}
+	}
+
+	assert.NoError(t, CheckKMSKey(es256, output(kmstypes.KeySpecEccNistP256, kmstypes.KeyUsageTypeSignVerify, kmstypes.SigningAlgorithmSpecEcdsaSha256)))
+	assert.NoError(t, CheckKMSKey(rs256, output(kmstypes.KeySpecRsa4096, kmstypes.KeyUsageTypeSignVerify, kmstypes.SigningAlgorithmSpecRsassaPkcs1V15Sha256)))
+
+	err = CheckKMSKey(es256, output(kmstypes.KeySpecEccNistP256, kmstypes.KeyUsageTypeEncryptDecrypt, kmstypes.SigningAlgorithmSpecEcdsaSha256))
+	assert.ErrorContains(t, err, "key usage")
+
+	err = CheckKMSKey(es256, output(kmstypes.KeySpecEccNistP384, kmstypes.KeyUsageTypeSignVerify, kmstypes.SigningAlgorithmSpecEcdsaSha384))
+	assert.ErrorContains(t, err, "key spec")
+
+	err = CheckKMSKey(rs256, output(kmstypes.KeySpecEccNistP256, kmstypes.KeyUsageTypeSignVerify, kmstypes.SigningAlgorithmSpecEcdsaSha256))
+	assert.ErrorContains(t, err, "RSA key spec")
+
+	err = CheckKMSKey(rs256, output(kmstypes.KeySpecRsa2048, kmstypes.KeyUsageTypeSignVerify, kmstypes.SigningAlgorithmSpecRsassaPssSha256))
+	assert.ErrorContains(t, err, "signing algorithm")
+}
@@ -0,0 +1,102 @@
+package issuer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/samber/lo"
+)
+
+// KeyState is the lifecycle state of a key in the keyring.
+type KeyState string
+
+const (
+	// KeyStatePending keys are published in the JWK Set but not used for
+	// signing yet.
+	KeyStatePending KeyState = "pending"
+
+	// KeyStateActive keys are published and used for signing.
+	KeyStateActive KeyState = "active"
+
+	// KeyStateRetiring keys are no longer used for signing, but stay published
+	// until tokens they signed have expired.
+	KeyStateRetiring KeyState = "retiring"
+
+	// KeyStateRetired keys are no longer used for signing or published.
+	KeyStateRetired KeyState = "retired"
+
+	// KeyStateRevoked keys are no longer used for signing or published,
+	// regardless of whether tokens they signed have expired.
+	KeyStateRevoked KeyState = "revoked"
+)
+
+// ErrKeyNotActive is returned when a request selects a key that is in the
+// keyring but not in the active state.
+var ErrKeyNotActive = errors.New("issuer: signing key is not active")
+
+var keyStateTransitions = map[KeyState][]KeyState{
+	KeyStatePending:  {KeyStateActive, KeyStateRevoked},
+	KeyStateActive:   {KeyStateRetiring, KeyStateRevoked},
+	KeyStateRetiring: {KeyStateRetired, KeyStateRevoked},
+	KeyStateRetired:  {KeyStateRevoked},
+	KeyStateRevoked:  {},
+}
+
+// Valid reports whether the state is a known lifecycle state.
+func (s KeyState) Valid() bool {
+	_, ok := keyStateTransitions[s]
+	return ok
+}
+
+// CanSign reports whether keys in the state are used for signing.
+func (s KeyState) CanSign() bool {
+	return s == KeyStateActive
+}
+
+// Published reports whether keys in the state are included in the JWK Set.
+func (s KeyState) Published() bool {
+	return s == KeyStatePending || s == KeyStateActive || s == KeyStateRetiring
+}
+
+// LifecycleState returns the state of the key. Keys written before states
+// were recorded have their state inferred from their timestamps.
+func (k KeyConfig) LifecycleState() KeyState {
+	switch {
+	case k.State != "":
+		return k.State
+	case k.RevokedAt != nil:
+		return KeyStateRevoked
+	case k.RetiredAt != nil:
+		return KeyStateRetired
+	case k.DeactivatedAt != nil:
+		return KeyStateRetiring
+	case k.ActivatedAt == nil && k.CreatedAt != nil:
+		return KeyStatePending
+	}
+
+	return KeyStateActive
+}
+
+// Transition moves the key to a new state, recording when it happened.
+func (k *KeyConfig) Transition(to KeyState, at time.Time) error {
+	from := k.LifecycleState()
+	if !slices.Contains(keyStateTransitions[from], to) {
+		return fmt.Errorf("issuer: key %q can't transition from %s to %s", k.KeyID, from, to)
+	}
+
+	k.State = to
+	switch to {
+	case KeyStateActive:
+		k.ActivatedAt = lo.ToPtr(at)
+	case KeyStateRetiring:
+		k.DeactivatedAt = lo.ToPtr(at)
+	case KeyStateRetired:
+		k.RetiredAt = lo.ToPtr(at)
+	case KeyStateRevoked:
+		k.RevokedAt = lo.ToPtr(at)
+	}
+
+	return nil
+}
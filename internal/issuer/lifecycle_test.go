@@ -0,0 +1,59 @@
+package issuer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_KeyState(t *testing.T) {
+	assert.True(t, KeyStateActive.CanSign())
+	assert.False(t, KeyStatePending.CanSign())
+	assert.False(t, KeyStateRetiring.CanSign())
+
+	assert.True(t, KeyStatePending.Published())
+	assert.True(t, KeyStateRetiring.Published())
+	assert.False(t, KeyStateRetired.Published())
+	assert.False(t, KeyStateRevoked.Published())
+
+	assert.True(t, KeyStateRevoked.Valid())
+	assert.False(t, KeyState("disabled").Valid())
+}
+
+func Test_KeyConfig_LifecycleState(t *testing.T) {
+	now := time.Now()
+
+	assert.Equal(t, KeyStateActive, KeyConfig{}.LifecycleState())
+	assert.Equal(t, KeyStatePending, KeyConfig{CreatedAt: &now}.LifecycleState())
+	assert.Equal(t, KeyStateActive, KeyConfig{CreatedAt: &now, ActivatedAt: &now}.LifecycleState())
+	assert.Equal(t, KeyStateRetiring, KeyConfig{ActivatedAt: &now, DeactivatedAt: &now}.LifecycleState())
+	assert.Equal(t, KeyStateRetired, KeyConfig{DeactivatedAt: &now, RetiredAt: &now}.LifecycleState())
+	assert.Equal(t, KeyStateRevoked, KeyConfig{RetiredAt: &now, RevokedAt: &now}.LifecycleState())
+	assert.Equal(t, KeyStateRevoked, KeyConfig{State: KeyStateRevoked}.LifecycleState())
+}
+
+func Test_KeyConfig_Transition(t *testing.T) {
+	created := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	key := KeyConfig{KeyID: "key", State: KeyStatePending, CreatedAt: &created}
+
+	for i, state := range []KeyState{KeyStateActive, KeyStateRetiring, KeyStateRetired, KeyStateRevoked} {
+		at := created.Add(time.Duration(i+1) * time.Hour)
+		require.NoError(t, key.Transition(state, at))
+		assert.Equal(t, state, key.State)
+	}
+
+	assert.Equal(t, created.Add(time.Hour), lo.FromPtr(key.ActivatedAt))
+	assert.Equal(t, created.Add(2*time.Hour), lo.FromPtr(key.DeactivatedAt))
+	assert.Equal(t, created.Add(3*time.Hour), lo.FromPtr(key.RetiredAt))
+	assert.Equal(t, created.Add(4*time.Hour), lo.FromPtr(key.RevokedAt))
+
+	err := key.Transition(KeyStateActive, created)
+	assert.ErrorContains(t, err, "can't transition from revoked to active")
+
+	pending := KeyConfig{KeyID: "pending", State: KeyStatePending}
+	assert.Error(t, pending.Transition(KeyStateRetiring, created))
+	assert.NoError(t, pending.Transition(KeyStateRevoked, created))
+}
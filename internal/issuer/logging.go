@@ -10,20 +10,23 @@ import (
 	"github.com/aws/aws-lambda-go/lambdacontext"
 )
 
-func HandlerWithLambdaLogging[E, R any](handler func(context.Context, E) (R, error)) func(context.Context, E) (R, error) {
-	var level slog.Level
+// lambdaLogLevel returns the function's application log level. Lambda drops
+// JSON log entries below it.
+func lambdaLogLevel() slog.Level {
 	switch os.Getenv("AWS_LAMBDA_LOG_LEVEL") {
 	case "DEBUG":
-		level = slog.LevelDebug
-	case "INFO":
-		level = slog.LevelInfo
+		return slog.LevelDebug
 	case "WARN":
-		level = slog.LevelWarn
+		return slog.LevelWarn
 	case "ERROR":
-		level = slog.LevelError
+		return slog.LevelError
 	default:
-		level = slog.LevelInfo
+		return slog.LevelInfo
 	}
+}
+
+func HandlerWithLambdaLogging[E, R any](handler func(context.Context, E) (R, error)) func(context.Context, E) (R, error) {
+	level := lambdaLogLevel()
 
 	return func(ctx context.Context, event E) (R, error) {
 		lc, _ := lambdacontext.FromContext(ctx)
@@ -35,19 +38,7 @@ func HandlerWithLambdaLogging[E, R any](handler func(context.Context, E) (R, err
 }
 
 func CloudFormationHandlerWithLambdaLogging(handler func(context.Context, cfn.Event) (string, map[string]any, error)) func(context.Context, cfn.Event) (string, map[string]any, error) {
-	var level slog.Level
-	switch os.Getenv("AWS_LAMBDA_LOG_LEVEL") {
-	case "DEBUG":
-		level = slog.LevelDebug
-	case "INFO":
-		level = slog.LevelInfo
-	case "WARN":
-		level = slog.LevelWarn
-	case "ERROR":
-		level = slog.LevelError
-	default:
-		level = slog.LevelInfo
-	}
+	level := lambdaLogLevel()
 
 	return func(ctx context.Context, event cfn.Event) (string, map[string]any, error) {
 		lc, _ := lambdacontext.FromContext(ctx)
@@ -73,3 +64,22 @@ func LogWithTiming(ctx context.Context, level slog.Level, message string, args .
 		slog.Log(ctx, level, "timing:"+message, "duration", duration.String(), "durationMs", durationMs)
 	}
 }
+
+// LogMetric logs a CloudWatch embedded metric format entry, which CloudWatch
+// turns into a metric in the JWTIssuer namespace with a FunctionName
+// dimension. The entry is logged at the given level, raised to the function's
+// log level if that's higher, so the metric is recorded in every deployment.
+func LogMetric(ctx context.Context, level slog.Level, name string, value float64) {
+	slog.Log(ctx, max(level, lambdaLogLevel()), "metric:"+name,
+		"_aws", map[string]any{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]any{{
+				"Namespace":  "JWTIssuer",
+				"Dimensions": [][]string{{"FunctionName"}},
+				"Metrics":    []map[string]string{{"Name": name, "Unit": "Count"}},
+			}},
+		},
+		"FunctionName", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+		name, value,
+	)
+}
@@ -0,0 +1,48 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LogMetric(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "issuer")
+	t.Setenv("AWS_LAMBDA_LOG_LEVEL", "ERROR")
+
+	var output bytes.Buffer
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelError})).With("requestId", "request-1"))
+
+	// Metrics are raised to the function's log level so they aren't dropped.
+	LogMetric(context.Background(), slog.LevelWarn, "KeyringReloadFailures", 1)
+	slog.Warn("hidden")
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(output.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, "metric:KeyringReloadFailures", entry["msg"])
+	assert.Equal(t, "request-1", entry["requestId"])
+	assert.Equal(t, "issuer", entry["FunctionName"])
+	assert.Equal(t, float64(1), entry["KeyringReloadFailures"])
+	assert.Equal(t, []any{map[string]any{
+		"Namespace":  "JWTIssuer",
+		"Dimensions": []any{[]any{"FunctionName"}},
+		"Metrics":    []any{map[string]any{"Name": "KeyringReloadFailures", "Unit": "Count"}},
+	}}, entry["_aws"].(map[string]any)["CloudWatchMetrics"])
+
+	// Lower log levels keep the metric's own level.
+	t.Setenv("AWS_LAMBDA_LOG_LEVEL", "DEBUG")
+	output.Reset()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	LogMetric(context.Background(), slog.LevelWarn, "KMSSignFailovers", 1)
+	require.NoError(t, json.Unmarshal(output.Bytes(), &entry))
+	assert.Equal(t, "WARN", entry["level"])
+	assert.Equal(t, "metric:KMSSignFailovers", entry["msg"])
+}
@@ -31,6 +31,20 @@ func PublicKeyParameterName() string {
 	return fmt.Sprintf("%s/public-key", parameterNamePrefix())
 }
 
+func JWKSParameterName() string {
+	return fmt.Sprintf("%s/jwks", parameterNamePrefix())
+}
+
+func KeyringParameterName() string {
+	return fmt.Sprintf("%s/keyring", parameterNamePrefix())
+}
+
+// RotatedKeyParameterName returns the name of a parameter for a key created by
+// the key rotator, such as "private-key" or "public-key".
+func RotatedKeyParameterName(id string, name string) string {
+	return fmt.Sprintf("%s/keys/%s/%s", parameterNamePrefix(), id, name)
+}
+
 func parameterNamePrefix() string {
 	stackArn, _ := arn.Parse(os.Getenv(StackArnEnvVar))
 	return fmt.Sprintf("/jwt-issuer/%s", stackArn.Resource)
@@ -23,3 +23,9 @@ func Test_PublicKeyParameterName(t *testing.T) {
 	name := PublicKeyParameterName()
 	assert.Equal(t, "/jwt-issuer/stack/JWTIssuer/d0a511e0-531d-11ee-8080-0a1f08df5697/public-key", name)
 }
+
+func Test_JWKSParameterName(t *testing.T) {
+	os.Setenv(StackArnEnvVar, "arn:aws:cloudformation:us-east-1:111111111111:stack/JWTIssuer/d0a511e0-531d-11ee-8080-0a1f08df5697")
+	name := JWKSParameterName()
+	assert.Equal(t, "/jwt-issuer/stack/JWTIssuer/d0a511e0-531d-11ee-8080-0a1f08df5697/jwks", name)
+}
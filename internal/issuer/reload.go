@@ -0,0 +1,242 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/samber/lo"
+)
+
+const KeyRefreshIntervalEnvVar = "KEY_REFRESH_INTERVAL"
+
+// ConfiguredKeyRefreshInterval returns how often issuer functions check for
+// key changes, in seconds. Zero disables the check.
+func ConfiguredKeyRefreshInterval() (time.Duration, error) {
+	seconds, err := intEnvVar(KeyRefreshIntervalEnvVar, 300)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// UnavailableError is returned when the issuer has no keys to sign with
+// because they couldn't be loaded.
+type UnavailableError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("issuer: unavailable, keys could not be loaded after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// KeyringReloader keeps the keyring of a warm execution environment up to
+// date. Every Interval it compares the versions of the keyring parameter and
+// the Parameter Store keys in it with the ones it last loaded, and swaps in a
+// new keyring when they differ. If Parameter Store is unavailable or the new
+// keyring can't be loaded, it keeps signing with the last good keyring.
+type KeyringReloader struct {
+	Loader    *KeyLoader
+	Custodian string
+	Interval  time.Duration
+
+	// How many times to try loading the keyring when there isn't one yet, and
+	// how long to wait before the first retry. The wait doubles after each
+	// attempt. Default to 3 attempts and 100ms.
+	Attempts int
+	Backoff  time.Duration
+
+	// Write the JWK Set parameter when a newly loaded keyring doesn't match
+	// it, so keyring edits such as revoking a key reach verifiers without a
+	// stack update. Failures are logged and don't affect signing.
+	PublishJWKS bool
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	keyring   *Keyring
+	versions  map[string]int64
+	checkedAt time.Time
+}
+
+// Keyring returns the current keyring, loading it on first use and reloading
+// it if its parameters have changed since the last check. If there's no
+// keyring yet and it can't be loaded, it returns an *UnavailableError and the
+// next call tries again.
+func (r *KeyringReloader) Keyring(ctx context.Context) (*Keyring, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.keyring == nil {
+		return r.load(ctx)
+	}
+
+	if r.Interval <= 0 || r.now().Before(r.checkedAt.Add(r.Interval)) {
+		return r.keyring, nil
+	}
+
+	if err := r.refresh(ctx); err != nil {
+		slog.WarnContext(ctx, "issuer.KeyringReloader: keeping last good keyring", "error", err)
+		LogMetric(ctx, slog.LevelWarn, "KeyringReloadFailures", 1)
+	}
+
+	return r.keyring, nil
+}
+
+// load makes the first attempts to load the keyring, backing off between
+// them.
+func (r *KeyringReloader) load(ctx context.Context) (*Keyring, error) {
+	attempts := r.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	var err error
+	attempt := 0
+	for attempt < attempts {
+		attempt++
+		if err = r.refresh(ctx); err == nil {
+			return r.keyring, nil
+		}
+
+		slog.WarnContext(ctx, "issuer.KeyringReloader: loading keyring failed", "attempt", attempt, "error", err)
+
+		if attempt < attempts {
+			if sleepErr := sleep(ctx, backoff); sleepErr != nil {
+				err = errors.Join(err, sleepErr)
+				break
+			}
+			backoff *= 2
+		}
+	}
+
+	slog.ErrorContext(ctx, "issuer.KeyringReloader: issuer unavailable", "attempts", attempt, "error", err)
+	LogMetric(ctx, slog.LevelError, "KeyringLoadFailures", 1)
+
+	return nil, &UnavailableError{Attempts: attempt, Err: err}
+}
+
+// SetKeyring replaces the current keyring. The next check reloads it if its
+// parameters have changed.
+func (r *KeyringReloader) SetKeyring(keyring *Keyring) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keyring = keyring
+	r.versions = nil
+	r.checkedAt = r.now()
+}
+
+// refresh loads the keyring if it has never been loaded or if its parameter
+// versions changed. The check is rescheduled whether or not it succeeds, so an
+// outage doesn't turn every request into a Parameter Store call.
+func (r *KeyringReloader) refresh(ctx context.Context) error {
+	r.checkedAt = r.now()
+
+	config, err := LoadKeyringConfig(ctx, r.Loader.SSM)
+	configured := err == nil
+	if errors.Is(err, ErrKeyringNotConfigured) {
+		config = KeyringConfig{Keys: []KeyConfig{DefaultKeyConfig(r.Custodian)}}
+	} else if err != nil {
+		return err
+	}
+
+	versions, err := r.parameterVersions(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if r.keyring != nil && maps.Equal(versions, r.versions) {
+		return nil
+	}
+
+	keyring, err := r.Loader.LoadKeyring(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	if r.versions != nil {
+		slog.InfoContext(ctx, "issuer.KeyringReloader: reloaded keyring", "versions", versions)
+	}
+
+	r.keyring = keyring
+	r.versions = versions
+
+	// Without a keyring parameter, the JWK Set is written with the stack.
+	if r.PublishJWKS && configured {
+		if written, err := r.Loader.SyncJWKSParameter(ctx, config); err != nil {
+			slog.WarnContext(ctx, "issuer.KeyringReloader: publishing JWK Set failed", "error", err)
+		} else if written {
+			slog.InfoContext(ctx, "issuer.KeyringReloader: published JWK Set")
+		}
+	}
+
+	return nil
+}
+
+// parameterVersions returns the version of the keyring parameter and of the
+// private key parameter of every Parameter Store key that can sign. KMS key
+// material never changes under the same ARN.
+func (r *KeyringReloader) parameterVersions(ctx context.Context, config KeyringConfig) (map[string]int64, error) {
+	names := []string{KeyringParameterName()}
+	for _, key := range config.Keys {
+		if key.Custodian == CustodianParameterStore && key.LifecycleState().CanSign() {
+			names = append(names, key.PrivateKeyParameter)
+		}
+	}
+
+	versions := map[string]int64{}
+	for _, name := range names {
+		getParamResponse, err := r.Loader.SSM.GetParameter(ctx, &ssm.GetParameterInput{
+			Name: lo.ToPtr(name),
+		})
+		if err != nil {
+			var notFound *ssmtypes.ParameterNotFound
+			if errors.As(err, &notFound) {
+				continue
+			}
+			return nil, err
+		}
+		versions[name] = getParamResponse.Parameter.Version
+	}
+
+	return versions, nil
+}
+
+// sleep waits for the duration, returning early with the context's error if
+// it's done first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (r *KeyringReloader) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
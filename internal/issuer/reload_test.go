@@ -0,0 +1,268 @@
+package issuer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_KeyringReloader(t *testing.T) {
+	t.Setenv(StackArnEnvVar, "arn:aws:cloudformation:us-east-1:111111111111:stack/JWTIssuer/d9385410-50ee-11ee-b05b-0a236ebfa8d3")
+
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+
+	putKey := func(t *testing.T, parameters map[string]string, versions map[string]int64) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		privateKeyPEM, err := EncodePrivateKeyPEM(privateKey)
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters[PrivateKeyParameterName()] = string(privateKeyPEM)
+		parameters[PublicKeyParameterName()] = string(publicKeyPEM)
+		versions[PrivateKeyParameterName()]++
+		versions[PublicKeyParameterName()]++
+	}
+
+	newReloader := func(parameters map[string]string, versions map[string]int64, unavailable *bool, now *time.Time) *KeyringReloader {
+		mockSSM := mocks.SSMAPI{}
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+			if *unavailable {
+				return nil, errors.New("ThrottlingException")
+			}
+			value, ok := parameters[lo.FromPtr(input.Name)]
+			if !ok {
+				return nil, &ssmtypes.ParameterNotFound{}
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{
+				Value:   lo.ToPtr(value),
+				Version: versions[lo.FromPtr(input.Name)],
+			}}, nil
+		})
+
+		mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+			parameters[lo.FromPtr(input.Name)] = lo.FromPtr(input.Value)
+			versions[lo.FromPtr(input.Name)]++
+			return &ssm.PutParameterOutput{}, nil
+		}).Maybe()
+
+		return &KeyringReloader{
+			Loader:    &KeyLoader{SSM: &mockSSM, KeyIDStrategy: KeyIDStrategyThumbprint},
+			Custodian: CustodianParameterStore,
+			Interval:  5 * time.Minute,
+			Backoff:   time.Millisecond,
+			Now:       func() time.Time { return *now },
+		}
+	}
+
+	t.Run("reloads the key after its parameter changes", func(t *testing.T) {
+		parameters := map[string]string{}
+		versions := map[string]int64{}
+		putKey(t, parameters, versions)
+
+		unavailable := false
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		reloader := newReloader(parameters, versions, &unavailable, &now)
+
+		keyring, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		first, err := keyring.Signer("", "")
+		require.NoError(t, err)
+
+		putKey(t, parameters, versions)
+
+		// The change isn't picked up until the refresh interval has passed.
+		now = now.Add(time.Minute)
+		keyring, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		signer, err := keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.Equal(t, first.KeyID(), signer.KeyID())
+
+		now = now.Add(5 * time.Minute)
+		keyring, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		second, err := keyring.Signer("", "")
+		require.NoError(t, err)
+		assert.NotEqual(t, first.KeyID(), second.KeyID())
+
+		// Unchanged versions keep the same keyring.
+		now = now.Add(5 * time.Minute)
+		reloaded, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, keyring, reloaded)
+	})
+
+	t.Run("publishes the JWK Set when a key that isn't active is revoked", func(t *testing.T) {
+		parameters := map[string]string{}
+		versions := map[string]int64{}
+		putKey(t, parameters, versions)
+
+		pendingKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		pendingPublicKeyPEM, err := EncodePublicKeyPEM(pendingKey.Public())
+		require.NoError(t, err)
+		parameters[RotatedKeyParameterName("pending", "public-key")] = string(pendingPublicKeyPEM)
+
+		putKeyring := func(pendingState KeyState) {
+			config := KeyringConfig{Keys: []KeyConfig{
+				DefaultKeyConfig(CustodianParameterStore),
+				{KeyID: "pending-key", Custodian: CustodianParameterStore, State: pendingState, PublicKeyParameter: RotatedKeyParameterName("pending", "public-key")},
+			}}
+			value, err := json.Marshal(config)
+			require.NoError(t, err)
+			parameters[KeyringParameterName()] = string(value)
+			versions[KeyringParameterName()]++
+		}
+		putKeyring(KeyStatePending)
+
+		unavailable := false
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		reloader := newReloader(parameters, versions, &unavailable, &now)
+		reloader.PublishJWKS = true
+
+		_, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.Len(t, jwksParameter(t, parameters).Keys, 2)
+
+		putKeyring(KeyStateRevoked)
+		now = now.Add(10 * time.Minute)
+		_, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+
+		jwks := jwksParameter(t, parameters)
+		require.Len(t, jwks.Keys, 1)
+		assert.NotEqual(t, "pending-key", jwks.Keys[0].KeyID)
+
+		// An unchanged JWK Set isn't written again.
+		jwksVersion := versions[JWKSParameterName()]
+		putKeyring(KeyStateRevoked)
+		now = now.Add(10 * time.Minute)
+		_, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, jwksVersion, versions[JWKSParameterName()])
+	})
+
+	t.Run("keeps the last good keyring when Parameter Store is unavailable", func(t *testing.T) {
+		parameters := map[string]string{}
+		versions := map[string]int64{}
+		putKey(t, parameters, versions)
+
+		unavailable := false
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		reloader := newReloader(parameters, versions, &unavailable, &now)
+
+		keyring, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+
+		unavailable = true
+		now = now.Add(10 * time.Minute)
+		reloaded, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, keyring, reloaded)
+
+		// A broken key is ignored the same way.
+		unavailable = false
+		parameters[PrivateKeyParameterName()] = "not a key"
+		versions[PrivateKeyParameterName()]++
+		now = now.Add(10 * time.Minute)
+		reloaded, err = reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, keyring, reloaded)
+	})
+
+	t.Run("fails without a keyring to fall back to", func(t *testing.T) {
+		parameters := map[string]string{}
+		versions := map[string]int64{}
+		putKey(t, parameters, versions)
+
+		unavailable := true
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		reloader := newReloader(parameters, versions, &unavailable, &now)
+
+		_, err := reloader.Keyring(context.Background())
+		var unavailableErr *UnavailableError
+		require.True(t, errors.As(err, &unavailableErr))
+		assert.Equal(t, 3, unavailableErr.Attempts)
+		assert.ErrorContains(t, err, "ThrottlingException")
+
+		// The next request tries again.
+		unavailable = false
+		keyring, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.NotNil(t, keyring)
+	})
+
+	t.Run("retries the first load", func(t *testing.T) {
+		parameters := map[string]string{}
+		versions := map[string]int64{}
+		putKey(t, parameters, versions)
+
+		failures := 2
+		mockSSM := mocks.SSMAPI{}
+		mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+			if failures > 0 {
+				failures--
+				return nil, errors.New("ThrottlingException")
+			}
+			value, ok := parameters[lo.FromPtr(input.Name)]
+			if !ok {
+				return nil, &ssmtypes.ParameterNotFound{}
+			}
+			return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(value)}}, nil
+		})
+
+		reloader := &KeyringReloader{
+			Loader:    &KeyLoader{SSM: &mockSSM, KeyIDStrategy: KeyIDStrategyThumbprint},
+			Custodian: CustodianParameterStore,
+			Backoff:   time.Millisecond,
+		}
+
+		keyring, err := reloader.Keyring(context.Background())
+		require.NoError(t, err)
+		assert.NotNil(t, keyring)
+	})
+
+	t.Run("stops retrying when the context is done", func(t *testing.T) {
+		unavailable := true
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		reloader := newReloader(map[string]string{}, map[string]int64{}, &unavailable, &now)
+		reloader.Backoff = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := reloader.Keyring(ctx)
+		var unavailableErr *UnavailableError
+		require.True(t, errors.As(err, &unavailableErr))
+		assert.Equal(t, 1, unavailableErr.Attempts)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func Test_ConfiguredKeyRefreshInterval(t *testing.T) {
+	interval, err := ConfiguredKeyRefreshInterval()
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, interval)
+
+	t.Setenv(KeyRefreshIntervalEnvVar, "0")
+	interval, err = ConfiguredKeyRefreshInterval()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	t.Setenv(KeyRefreshIntervalEnvVar, "soon")
+	_, err = ConfiguredKeyRefreshInterval()
+	assert.Error(t, err)
+}
@@ -0,0 +1,377 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+)
+
+const (
+	KeyRotationIntervalDaysEnvVar = "KEY_ROTATION_INTERVAL_DAYS"
+	KeyPromotionDelayHoursEnvVar  = "KEY_PROMOTION_DELAY_HOURS"
+	MaxTokenTTLEnvVar             = "MAX_TOKEN_TTL"
+)
+
+// StackTagKey is the tag applied to KMS keys created by the key rotator, with
+// the stack ARN as its value.
+const StackTagKey = "jwt-issuer:stack"
+
+// RotationPolicy controls when the key rotator creates, promotes and retires
+// keys.
+type RotationPolicy struct {
+	// How long a key is active before a replacement is created.
+	Interval time.Duration
+
+	// How long a new key is published before it becomes the active key, so
+	// verifiers have time to pick it up.
+	PromotionDelay time.Duration
+
+	// The longest lifetime of an issued token. A replaced key is published
+	// until every token it signed has expired.
+	MaxTokenTTL time.Duration
+
+	// How long warm issuers keep signing with a replaced key before they
+	// reload the keyring, and how far verifier clocks may lag. Both extend
+	// how long a replaced key is published.
+	KeyRefreshInterval time.Duration
+	ClockSkew          time.Duration
+}
+
+// RetirementDelay returns how long after a key stops being the active key
+// it's retired: long enough for issuers to stop signing with it and for the
+// last token it signed to expire.
+func (p RotationPolicy) RetirementDelay() time.Duration {
+	return p.KeyRefreshInterval + p.MaxTokenTTL + p.ClockSkew
+}
+
+// ConfiguredRotationPolicy returns the rotation policy configured for the
+// stack.
+func ConfiguredRotationPolicy() (RotationPolicy, error) {
+	intervalDays, err := intEnvVar(KeyRotationIntervalDaysEnvVar, 90)
+	if err != nil {
+		return RotationPolicy{}, err
+	}
+
+	promotionDelayHours, err := intEnvVar(KeyPromotionDelayHoursEnvVar, 24)
+	if err != nil {
+		return RotationPolicy{}, err
+	}
+
+	maxTokenTTL, err := intEnvVar(MaxTokenTTLEnvVar, 86400)
+	if err != nil {
+		return RotationPolicy{}, err
+	}
+
+	refreshInterval, err := ConfiguredKeyRefreshInterval()
+	if err != nil {
+		return RotationPolicy{}, err
+	}
+
+	clockSkew, err := intEnvVar(ClockSkewEnvVar, 0)
+	if err != nil {
+		return RotationPolicy{}, err
+	}
+
+	return RotationPolicy{
+		Interval:           time.Duration(intervalDays) * 24 * time.Hour,
+		PromotionDelay:     time.Duration(promotionDelayHours) * time.Hour,
+		MaxTokenTTL:        time.Duration(maxTokenTTL) * time.Second,
+		KeyRefreshInterval: refreshInterval,
+		ClockSkew:          time.Duration(clockSkew) * time.Second,
+	}, nil
+}
+
+// RotationResult describes the changes made by a single rotation run.
+type RotationResult struct {
+	Created  string   `json:"created,omitempty"`
+	Promoted string   `json:"promoted,omitempty"`
+	Retired  []string `json:"retired,omitempty"`
+}
+
+// KeyRotator rotates the keys in the stack's keyring. Each run advances the
+// rotation as far as the policy allows: a new pending key is created and
+// published once the active key is older than the rotation interval, promoted
+// to active after the promotion delay, and the key it replaced is retiring
+// until every token it signed has expired, when it's retired. A revoked active
+// key is replaced immediately.
+type KeyRotator struct {
+	SSM           SSMAPI
+	KMS           KMSAPI
+	Custodian     string
+	Algorithm     SigningAlgorithm
+	KeyIDStrategy KeyIDStrategy
+	Policy        RotationPolicy
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Rotate runs a single rotation step, writing the keyring parameter if
+// anything changed and the JWK Set parameter if it doesn't match the keyring.
+func (r *KeyRotator) Rotate(ctx context.Context) (RotationResult, error) {
+	var result RotationResult
+	now := r.now()
+	loader := &KeyLoader{SSM: r.SSM, KMS: r.KMS, KeyIDStrategy: r.KeyIDStrategy}
+
+	config, err := LoadKeyringConfig(ctx, r.SSM)
+	changed := false
+	if errors.Is(err, ErrKeyringNotConfigured) {
+		config, err = r.bootstrap(ctx, loader, now)
+		changed = true
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if config.ActiveKeyID == "" && len(config.Keys) > 0 {
+		config.ActiveKeyID = config.Keys[0].KeyID
+	}
+
+	activeIndex := slices.IndexFunc(config.Keys, func(k KeyConfig) bool { return k.KeyID == config.ActiveKeyID })
+	if activeIndex < 0 {
+		return result, fmt.Errorf("issuer: active kid %q is not in the keyring", config.ActiveKeyID)
+	}
+
+	// Retire keys once every token they signed has expired.
+	for i := range config.Keys {
+		key := &config.Keys[i]
+		if key.LifecycleState() != KeyStateRetiring || key.DeactivatedAt == nil || now.Before(key.DeactivatedAt.Add(r.Policy.RetirementDelay())) {
+			continue
+		}
+
+		if err := r.destroyKey(ctx, *key); err != nil {
+			return result, err
+		}
+		if err := key.Transition(KeyStateRetired, now); err != nil {
+			return result, err
+		}
+		result.Retired = append(result.Retired, key.KeyID)
+		changed = true
+	}
+
+	active := &config.Keys[activeIndex]
+
+	// Keyrings written by hand or before rotation was enabled may not record
+	// when the active key was activated. Its age is counted from now rather
+	// than rotating it straight away.
+	if active.ActivatedAt == nil && (active.State == "" || active.State == KeyStateActive) {
+		slog.WarnContext(ctx, "issuer.KeyRotator: active key has no activatedAt, counting its age from now", "kid", active.KeyID)
+		active.ActivatedAt = lo.ToPtr(now)
+		changed = true
+	}
+
+	activeRevoked := active.LifecycleState() != KeyStateActive
+	pendingIndex := slices.IndexFunc(config.Keys, func(k KeyConfig) bool {
+		return k.LifecycleState() == KeyStatePending && k.CreatedAt != nil
+	})
+
+	// Create a replacement once the active key is due for rotation, or right
+	// away if it has been revoked.
+	if pendingIndex < 0 && (activeRevoked || !now.Before(active.ActivatedAt.Add(r.Policy.Interval))) {
+		key, err := r.createKey(ctx, loader, now)
+		if err != nil {
+			return result, err
+		}
+
+		config.Keys = append(config.Keys, key)
+		active = &config.Keys[activeIndex]
+		pendingIndex = len(config.Keys) - 1
+		result.Created = key.KeyID
+		changed = true
+	}
+
+	// Promote the replacement once verifiers have had time to pick it up, or
+	// right away if the active key has been revoked.
+	if pendingIndex >= 0 && (activeRevoked || !now.Before(config.Keys[pendingIndex].CreatedAt.Add(r.Policy.PromotionDelay))) {
+		pending := &config.Keys[pendingIndex]
+		if err := pending.Transition(KeyStateActive, now); err != nil {
+			return result, err
+		}
+		if !activeRevoked {
+			if err := active.Transition(KeyStateRetiring, now); err != nil {
+				return result, err
+			}
+		}
+
+		// Profiles follow the active key to its replacement.
+		for profile, keyID := range config.Profiles {
+			if keyID == active.KeyID {
+				config.Profiles[profile] = pending.KeyID
+			}
+		}
+
+		config.ActiveKeyID = pending.KeyID
+		result.Promoted = pending.KeyID
+		changed = true
+	}
+
+	if changed {
+		if err := PutKeyringParameter(ctx, r.SSM, config); err != nil {
+			return result, err
+		}
+	}
+
+	// The JWK Set is checked on every run, since edits to the keyring such as
+	// revoking a key that isn't active don't change anything here.
+	_, err = loader.SyncJWKSParameter(ctx, config)
+
+	return result, err
+}
+
+// bootstrap returns a keyring configuration for a stack that doesn't have one
+// yet, holding the key created with the stack as the active key.
+func (r *KeyRotator) bootstrap(ctx context.Context, loader *KeyLoader, now time.Time) (KeyringConfig, error) {
+	key := DefaultKeyConfig(r.Custodian)
+
+	jwk, err := loader.LoadJWK(ctx, key)
+	if err != nil {
+		return KeyringConfig{}, err
+	}
+
+	key.KeyID = jwk.KeyID
+	key.State = KeyStateActive
+	key.ActivatedAt = lo.ToPtr(now)
+
+	return KeyringConfig{ActiveKeyID: key.KeyID, Keys: []KeyConfig{key}}, nil
+}
+
+func (r *KeyRotator) createKey(ctx context.Context, loader *KeyLoader, now time.Time) (KeyConfig, error) {
+	key := KeyConfig{
+		Custodian: r.Custodian,
+		Algorithm: r.Algorithm.Name(),
+		State:     KeyStatePending,
+		CreatedAt: lo.ToPtr(now),
+	}
+
+	var resourceID string
+
+	if r.Custodian == CustodianKMS {
+		createKeyResponse, err := r.KMS.CreateKey(ctx, &kms.CreateKeyInput{
+			Description: lo.ToPtr("JWT Issuer Signing Key"),
+			KeySpec:     r.Algorithm.KMSKeySpec,
+			KeyUsage:    kmstypes.KeyUsageTypeSignVerify,
+			Tags: []kmstypes.Tag{{
+				TagKey:   lo.ToPtr(StackTagKey),
+				TagValue: lo.ToPtr(os.Getenv(StackArnEnvVar)),
+			}},
+		})
+		if err != nil {
+			return KeyConfig{}, err
+		}
+
+		key.KeyArn = lo.FromPtr(createKeyResponse.KeyMetadata.Arn)
+		resourceID, err = KMSKeyResourceID(key.KeyArn)
+		if err != nil {
+			return KeyConfig{}, err
+		}
+	} else {
+		resourceID = uuid.NewString()
+		key.PrivateKeyParameter = RotatedKeyParameterName(resourceID, "private-key")
+		key.PublicKeyParameter = RotatedKeyParameterName(resourceID, "public-key")
+
+		privateKey, err := r.Algorithm.GenerateKey()
+		if err != nil {
+			return KeyConfig{}, err
+		}
+		privateKeyPEM, err := EncodePrivateKeyPEM(privateKey)
+		if err != nil {
+			return KeyConfig{}, err
+		}
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		if err != nil {
+			return KeyConfig{}, err
+		}
+
+		for _, parameter := range []struct {
+			name  string
+			value []byte
+		}{
+			{key.PrivateKeyParameter, privateKeyPEM},
+			{key.PublicKeyParameter, publicKeyPEM},
+		} {
+			_, err = r.SSM.PutParameter(ctx, &ssm.PutParameterInput{
+				DataType:    lo.ToPtr("text"),
+				Description: lo.ToPtr("JWT Issuer Rotated Key"),
+				Name:        lo.ToPtr(parameter.name),
+				Overwrite:   lo.ToPtr(false),
+				Type:        ssmtypes.ParameterTypeSecureString,
+				Value:       lo.ToPtr(string(parameter.value)),
+			})
+			if err != nil {
+				return KeyConfig{}, err
+			}
+		}
+	}
+
+	// Derive the kid from the new key's own resource ID rather than the stack's,
+	// and pin it in the keyring so it never changes.
+	publicKey, err := loader.LoadPublicKey(ctx, key)
+	if err != nil {
+		return KeyConfig{}, err
+	}
+
+	key.KeyID, err = r.KeyIDStrategy.KeyID(resourceID, publicKey)
+	if err != nil {
+		return KeyConfig{}, err
+	}
+
+	return key, nil
+}
+
+// destroyKey deletes the key material for a retired key. Keys created with the
+// stack are left for CloudFormation to delete.
+func (r *KeyRotator) destroyKey(ctx context.Context, key KeyConfig) error {
+	switch key.Custodian {
+	case CustodianKMS:
+		if key.KeyArn == os.Getenv(SigningKeyArnEnvVar) {
+			return nil
+		}
+		_, err := r.KMS.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{
+			KeyId: lo.ToPtr(key.KeyArn),
+		})
+		return err
+	case CustodianParameterStore:
+		if key.PrivateKeyParameter == PrivateKeyParameterName() {
+			return nil
+		}
+		_, err := r.SSM.DeleteParameters(ctx, &ssm.DeleteParametersInput{
+			Names: []string{key.PrivateKeyParameter, key.PublicKeyParameter},
+		})
+		return err
+	}
+
+	return nil
+}
+
+func (r *KeyRotator) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+func intEnvVar(name string, defaultValue int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("issuer: %s must be a non-negative integer, got %q", name, value)
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,410 @@
+package issuer
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/hotsock/jwt-issuer/internal/mocks"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_KeyRotator(t *testing.T) {
+	t.Setenv(StackArnEnvVar, "arn:aws:cloudformation:us-east-1:111111111111:stack/JWTIssuer/d9385410-50ee-11ee-b05b-0a236ebfa8d3")
+
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+
+	policy := RotationPolicy{
+		Interval:       90 * 24 * time.Hour,
+		PromotionDelay: 24 * time.Hour,
+		MaxTokenTTL:    time.Hour,
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("waits for issuers to reload and clocks to catch up before retiring", func(t *testing.T) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters := map[string]string{PublicKeyParameterName(): string(publicKeyPEM)}
+
+		now := start
+		rotator := KeyRotator{
+			SSM:           parameterStore(parameters),
+			Custodian:     CustodianParameterStore,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        RotationPolicy{Interval: policy.Interval, PromotionDelay: policy.PromotionDelay, MaxTokenTTL: time.Hour, KeyRefreshInterval: 5 * time.Minute, ClockSkew: 30 * time.Second},
+			Now:           func() time.Time { return now },
+		}
+		assert.Equal(t, time.Hour+5*time.Minute+30*time.Second, rotator.Policy.RetirementDelay())
+
+		for _, step := range []time.Duration{0, 90 * 24 * time.Hour, 24 * time.Hour} {
+			now = now.Add(step)
+			_, err = rotator.Rotate(context.Background())
+			require.NoError(t, err)
+		}
+
+		// Issuers may have signed with the old key until they reloaded the
+		// keyring, so it's still published an hour later.
+		now = now.Add(time.Hour + 5*time.Minute)
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, result.Retired)
+
+		now = now.Add(30 * time.Second)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d9385410-50ee-11ee-b05b-0a236ebfa8d3"}, result.Retired)
+	})
+
+	t.Run("unpublishes a revoked retiring key", func(t *testing.T) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters := map[string]string{PublicKeyParameterName(): string(publicKeyPEM)}
+		mockSSM := parameterStore(parameters)
+
+		now := start
+		rotator := KeyRotator{
+			SSM:           mockSSM,
+			Custodian:     CustodianParameterStore,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        policy,
+			Now:           func() time.Time { return now },
+		}
+
+		for _, step := range []time.Duration{0, 90 * 24 * time.Hour, 24 * time.Hour} {
+			now = now.Add(step)
+			_, err = rotator.Rotate(context.Background())
+			require.NoError(t, err)
+		}
+		require.Len(t, jwksParameter(t, parameters).Keys, 2)
+
+		keyring := keyringParameter(t, parameters)
+		require.Equal(t, KeyStateRetiring, keyring.Keys[0].State)
+		require.NoError(t, keyring.Keys[0].Transition(KeyStateRevoked, now))
+		require.NoError(t, PutKeyringParameter(context.Background(), mockSSM, keyring))
+
+		// Nothing in the keyring is due to change, but the JWK Set is rewritten.
+		now = now.Add(time.Minute)
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, RotationResult{}, result)
+
+		jwks := jwksParameter(t, parameters)
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, keyring.ActiveKeyID, jwks.Keys[0].KeyID)
+	})
+
+	t.Run("counts the age of an active key without activatedAt from now", func(t *testing.T) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters := map[string]string{PublicKeyParameterName(): string(publicKeyPEM)}
+		mockSSM := parameterStore(parameters)
+
+		// A keyring written by hand, before rotation was enabled.
+		key := DefaultKeyConfig(CustodianParameterStore)
+		key.KeyID = "d9385410-50ee-11ee-b05b-0a236ebfa8d3"
+		require.NoError(t, PutKeyringParameter(context.Background(), mockSSM, KeyringConfig{ActiveKeyID: key.KeyID, Keys: []KeyConfig{key}}))
+
+		now := start
+		rotator := KeyRotator{
+			SSM:           mockSSM,
+			Custodian:     CustodianParameterStore,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        policy,
+			Now:           func() time.Time { return now },
+		}
+
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, RotationResult{}, result)
+
+		keyring := keyringParameter(t, parameters)
+		require.Len(t, keyring.Keys, 1)
+		assert.Equal(t, &start, keyring.Keys[0].ActivatedAt)
+
+		now = now.Add(90*24*time.Hour - time.Minute)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, result.Created)
+
+		now = now.Add(time.Minute)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.NotEmpty(t, result.Created)
+	})
+
+	t.Run("rotates Parameter Store keys", func(t *testing.T) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters := map[string]string{PublicKeyParameterName(): string(publicKeyPEM)}
+		mockSSM := parameterStore(parameters)
+
+		now := start
+		rotator := KeyRotator{
+			SSM:           mockSSM,
+			Custodian:     CustodianParameterStore,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        policy,
+			Now:           func() time.Time { return now },
+		}
+
+		// The first run records the stack's key as the active key.
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, RotationResult{}, result)
+
+		keyring := keyringParameter(t, parameters)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", keyring.ActiveKeyID)
+		require.Len(t, keyring.Keys, 1)
+		assert.Equal(t, start, lo.FromPtr(keyring.Keys[0].ActivatedAt))
+
+		// Nothing happens before the rotation interval has passed.
+		now = start.Add(89 * 24 * time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, RotationResult{}, result)
+
+		// A new key is created and published, but not active yet.
+		now = start.Add(90 * 24 * time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		require.NotEmpty(t, result.Created)
+
+		keyring = keyringParameter(t, parameters)
+		assert.Equal(t, "d9385410-50ee-11ee-b05b-0a236ebfa8d3", keyring.ActiveKeyID)
+		require.Len(t, keyring.Keys, 2)
+		newKey := keyring.Keys[1]
+		assert.Equal(t, result.Created, newKey.KeyID)
+		assert.Equal(t, KeyStatePending, newKey.State)
+		assert.Equal(t, RotatedKeyParameterName(newKey.KeyID, "private-key"), newKey.PrivateKeyParameter)
+		assert.Contains(t, parameters, newKey.PrivateKeyParameter)
+		assert.Len(t, jwksParameter(t, parameters).Keys, 2)
+
+		// The new key is promoted after the promotion delay.
+		now = now.Add(24 * time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, newKey.KeyID, result.Promoted)
+
+		keyring = keyringParameter(t, parameters)
+		assert.Equal(t, newKey.KeyID, keyring.ActiveKeyID)
+		assert.Equal(t, KeyStateRetiring, keyring.Keys[0].State)
+		assert.Equal(t, now, lo.FromPtr(keyring.Keys[0].DeactivatedAt))
+		assert.Equal(t, KeyStateActive, keyring.Keys[1].State)
+
+		// The old key is retired once tokens it signed have expired. The stack's
+		// own key parameters are left for CloudFormation.
+		now = now.Add(time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"d9385410-50ee-11ee-b05b-0a236ebfa8d3"}, result.Retired)
+
+		keyring = keyringParameter(t, parameters)
+		require.Len(t, keyring.Keys, 2)
+		assert.Equal(t, KeyStateRetired, keyring.Keys[0].State)
+		assert.Equal(t, now, lo.FromPtr(keyring.Keys[0].RetiredAt))
+		assert.Equal(t, KeyStateActive, keyring.Keys[1].State)
+		assert.Contains(t, parameters, PublicKeyParameterName())
+		assert.Len(t, jwksParameter(t, parameters).Keys, 1)
+
+		// Rotated keys are deleted when they're retired.
+		now = now.Add(90 * 24 * time.Hour)
+		_, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		now = now.Add(24 * time.Hour)
+		_, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		now = now.Add(time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{newKey.KeyID}, result.Retired)
+		assert.NotContains(t, parameters, newKey.PrivateKeyParameter)
+		assert.NotContains(t, parameters, newKey.PublicKeyParameter)
+	})
+
+	t.Run("replaces a revoked active key immediately", func(t *testing.T) {
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyPEM, err := EncodePublicKeyPEM(privateKey.Public())
+		require.NoError(t, err)
+
+		revoked, err := json.Marshal(KeyringConfig{
+			ActiveKeyID: "compromised",
+			Profiles:    map[string]string{"billing": "compromised"},
+			Keys: []KeyConfig{{
+				KeyID:              "compromised",
+				Custodian:          CustodianParameterStore,
+				PublicKeyParameter: PublicKeyParameterName(),
+				State:              KeyStateRevoked,
+				ActivatedAt:        lo.ToPtr(start),
+			}},
+		})
+		require.NoError(t, err)
+
+		parameters := map[string]string{
+			PublicKeyParameterName(): string(publicKeyPEM),
+			KeyringParameterName():   string(revoked),
+		}
+
+		rotator := KeyRotator{
+			SSM:           parameterStore(parameters),
+			Custodian:     CustodianParameterStore,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        policy,
+			Now:           func() time.Time { return start.Add(time.Hour) },
+		}
+
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.NotEmpty(t, result.Created)
+		assert.Equal(t, result.Created, result.Promoted)
+
+		keyring := keyringParameter(t, parameters)
+		assert.Equal(t, result.Created, keyring.ActiveKeyID)
+		assert.Equal(t, result.Created, keyring.Profiles["billing"])
+		assert.Equal(t, KeyStateRevoked, keyring.Keys[0].State)
+		assert.Equal(t, KeyStateActive, keyring.Keys[1].State)
+
+		jwks := jwksParameter(t, parameters)
+		require.Len(t, jwks.Keys, 1)
+		assert.Equal(t, result.Created, jwks.Keys[0].KeyID)
+	})
+
+	t.Run("rotates KMS keys", func(t *testing.T) {
+		stackKeyArn := "arn:aws:kms:us-east-1:111111111111:key/4a2c1b37-e4c8-466a-b873-11aaf144b01b"
+		newKeyArn := "arn:aws:kms:us-east-1:111111111111:key/0f6b8c0e-3d5f-4b7a-9a47-b1b5a1f0f7a2"
+		t.Setenv(SigningKeyArnEnvVar, stackKeyArn)
+
+		privateKey, err := alg.GenerateKey()
+		require.NoError(t, err)
+		publicKeyDER, err := x509.MarshalPKIXPublicKey(privateKey.Public())
+		require.NoError(t, err)
+
+		parameters := map[string]string{}
+		mockSSM := parameterStore(parameters)
+
+		mockKMS := mocks.KMSAPI{}
+		mockKMS.On("GetPublicKey", mock.Anything, mock.Anything).Return(&kms.GetPublicKeyOutput{PublicKey: publicKeyDER}, nil)
+		mockKMS.On("CreateKey", mock.Anything, mock.Anything).Return(&kms.CreateKeyOutput{KeyMetadata: &kmstypes.KeyMetadata{Arn: lo.ToPtr(newKeyArn)}}, nil)
+		mockKMS.On("ScheduleKeyDeletion", mock.Anything, mock.Anything).Return(&kms.ScheduleKeyDeletionOutput{}, nil)
+
+		now := start
+		rotator := KeyRotator{
+			SSM:           mockSSM,
+			KMS:           &mockKMS,
+			Custodian:     CustodianKMS,
+			Algorithm:     alg,
+			KeyIDStrategy: KeyIDStrategyResourceID,
+			Policy:        policy,
+			Now:           func() time.Time { return now },
+		}
+
+		_, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+
+		now = start.Add(90 * 24 * time.Hour)
+		result, err := rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "0f6b8c0e-3d5f-4b7a-9a47-b1b5a1f0f7a2", result.Created)
+
+		createKeyCall, _ := lo.Find(mockKMS.Calls, func(call mock.Call) bool { return call.Method == "CreateKey" })
+		createKeyInput := createKeyCall.Arguments[1].(*kms.CreateKeyInput)
+		assert.Equal(t, kmstypes.KeySpecEccNistP256, createKeyInput.KeySpec)
+		assert.Equal(t, kmstypes.KeyUsageTypeSignVerify, createKeyInput.KeyUsage)
+		assert.Equal(t, StackTagKey, lo.FromPtr(createKeyInput.Tags[0].TagKey))
+
+		now = now.Add(24 * time.Hour)
+		_, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+
+		// The stack's key is removed from the keyring but not deleted.
+		now = now.Add(time.Hour)
+		result, err = rotator.Rotate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"4a2c1b37-e4c8-466a-b873-11aaf144b01b"}, result.Retired)
+		mockKMS.AssertNotCalled(t, "ScheduleKeyDeletion", mock.Anything, mock.Anything)
+	})
+}
+
+func Test_ConfiguredRotationPolicy(t *testing.T) {
+	policy, err := ConfiguredRotationPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, RotationPolicy{Interval: 90 * 24 * time.Hour, PromotionDelay: 24 * time.Hour, MaxTokenTTL: 24 * time.Hour, KeyRefreshInterval: 5 * time.Minute}, policy)
+
+	t.Setenv(KeyRotationIntervalDaysEnvVar, "30")
+	t.Setenv(KeyPromotionDelayHoursEnvVar, "1")
+	t.Setenv(MaxTokenTTLEnvVar, "300")
+	t.Setenv(KeyRefreshIntervalEnvVar, "60")
+	t.Setenv(ClockSkewEnvVar, "30")
+	policy, err = ConfiguredRotationPolicy()
+	require.NoError(t, err)
+	assert.Equal(t, RotationPolicy{Interval: 30 * 24 * time.Hour, PromotionDelay: time.Hour, MaxTokenTTL: 5 * time.Minute, KeyRefreshInterval: time.Minute, ClockSkew: 30 * time.Second}, policy)
+
+	t.Setenv(MaxTokenTTLEnvVar, "-1")
+	_, err = ConfiguredRotationPolicy()
+	assert.Error(t, err)
+}
+
+// parameterStore returns an SSM mock backed by the parameters map.
+func parameterStore(parameters map[string]string) *mocks.SSMAPI {
+	mockSSM := mocks.SSMAPI{}
+	mockSSM.On("GetParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.GetParameterInput, _ ...func(*ssm.Options)) (*ssm.GetParameterOutput, error) {
+		value, ok := parameters[lo.FromPtr(input.Name)]
+		if !ok {
+			return nil, &ssmtypes.ParameterNotFound{}
+		}
+		return &ssm.GetParameterOutput{Parameter: &ssmtypes.Parameter{Value: lo.ToPtr(value)}}, nil
+	})
+	mockSSM.On("PutParameter", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.PutParameterInput, _ ...func(*ssm.Options)) (*ssm.PutParameterOutput, error) {
+		if _, ok := parameters[lo.FromPtr(input.Name)]; ok && !lo.FromPtr(input.Overwrite) {
+			return nil, &ssmtypes.ParameterAlreadyExists{}
+		}
+		parameters[lo.FromPtr(input.Name)] = lo.FromPtr(input.Value)
+		return &ssm.PutParameterOutput{}, nil
+	})
+	mockSSM.On("DeleteParameters", mock.Anything, mock.Anything).Return(func(_ context.Context, input *ssm.DeleteParametersInput, _ ...func(*ssm.Options)) (*ssm.DeleteParametersOutput, error) {
+		for _, name := range input.Names {
+			delete(parameters, name)
+		}
+		return &ssm.DeleteParametersOutput{}, nil
+	})
+	return &mockSSM
+}
+
+func keyringParameter(t *testing.T, parameters map[string]string) KeyringConfig {
+	var config KeyringConfig
+	require.NoError(t, json.Unmarshal([]byte(parameters[KeyringParameterName()]), &config))
+	return config
+}
+
+func jwksParameter(t *testing.T, parameters map[string]string) JWKSet {
+	var set JWKSet
+	require.NoError(t, json.Unmarshal([]byte(parameters[JWKSParameterName()]), &set))
+	return set
+}
@@ -0,0 +1,109 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer produces JWS signatures for a single key, regardless of where the
+// private key material is held.
+type Signer interface {
+	// Sign returns the raw (not base64-encoded) JWS signature for the signing
+	// string.
+	Sign(ctx context.Context, signingString string) ([]byte, error)
+
+	// Algorithm returns the JWS "alg" header value for signatures produced by
+	// this signer.
+	Algorithm() string
+
+	// KeyID returns the "kid" header value for tokens signed by this signer, or
+	// an empty string if tokens should not include one.
+	KeyID() string
+
+	// PublicKey returns the public key that verifies signatures produced by this
+	// signer.
+	PublicKey() crypto.PublicKey
+}
+
+// LocalSigner signs with a private key that is held in memory.
+type LocalSigner struct {
+	alg        SigningAlgorithm
+	privateKey crypto.Signer
+	keyID      string
+}
+
+// NewLocalSigner returns a signer for an in-memory private key, returning an
+// error if the key cannot be used with the algorithm.
+func NewLocalSigner(alg SigningAlgorithm, privateKey crypto.Signer, keyID string) (*LocalSigner, error) {
+	if err := alg.CheckPublicKey(privateKey.Public()); err != nil {
+		return nil, err
+	}
+
+	return &LocalSigner{alg: alg, privateKey: privateKey, keyID: keyID}, nil
+}
+
+func (s *LocalSigner) Sign(ctx context.Context, signingString string) ([]byte, error) {
+	return s.alg.Method.Sign(signingString, s.privateKey)
+}
+
+func (s *LocalSigner) Algorithm() string {
+	return s.alg.Name()
+}
+
+func (s *LocalSigner) KeyID() string {
+	return s.keyID
+}
+
+func (s *LocalSigner) PublicKey() crypto.PublicKey {
+	return s.privateKey.Public()
+}
+
+// selfTestSigningString is signed by SelfTest. It's never used in a token.
+const selfTestSigningString = "jwt-issuer.self-test"
+
+// SelfTest signs a probe with the signer and verifies the signature with the
+// public key that verifiers are given for it, returning an error if the
+// signer's private key doesn't match.
+func SelfTest(ctx context.Context, signer Signer, publicKey crypto.PublicKey) error {
+	alg, err := LookupSigningAlgorithm(signer.Algorithm())
+	if err != nil {
+		return err
+	}
+
+	signature, err := signer.Sign(ctx, selfTestSigningString)
+	if err != nil {
+		return fmt.Errorf("issuer: self-test for kid %q failed to sign: %w", signer.KeyID(), err)
+	}
+
+	if err := alg.Method.Verify(selfTestSigningString, signature, publicKey); err != nil {
+		return fmt.Errorf("issuer: self-test for kid %q failed, the private key doesn't match the published public key: %w", signer.KeyID(), err)
+	}
+
+	return nil
+}
+
+// SignJWT signs the token with the signer and returns the compact serialized
+// JWT.
+func SignJWT(ctx context.Context, signer Signer, token *jwt.Token) (string, error) {
+	defer LogWithTiming(ctx, slog.LevelDebug, "issuer.SignJWT", "token", token, "kid", signer.KeyID())()
+
+	sstr, err := token.SigningString()
+	if err != nil {
+		return "", NewError(ErrorCodeInvalidInput, "issuer: claims can't be encoded: %w", err)
+	}
+
+	signature, err := signer.Sign(ctx, sstr)
+	if err != nil {
+		return "", err
+	}
+
+	sig := base64.RawURLEncoding.EncodeToString(signature)
+
+	return strings.Join([]string{sstr, sig}, "."), nil
+}
@@ -0,0 +1,72 @@
+package issuer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LocalSigner(t *testing.T) {
+	for _, name := range []string{"ES256", "ES384", "ES512", "RS256", "PS256", "EdDSA"} {
+		t.Run(name, func(t *testing.T) {
+			alg, err := LookupSigningAlgorithm(name)
+			require.NoError(t, err)
+
+			privateKey, err := alg.GenerateKey()
+			require.NoError(t, err)
+
+			signer, err := NewLocalSigner(alg, privateKey, "local-key")
+			require.NoError(t, err)
+			assert.Equal(t, name, signer.Algorithm())
+			assert.Equal(t, "local-key", signer.KeyID())
+			assert.Equal(t, privateKey.Public(), signer.PublicKey())
+
+			unsigned, err := PrepareToken(JWTIssuerFunctionInput{Claims: jwt.MapClaims{"foo": "bar"}}, signer, TokenPolicy{})
+			require.NoError(t, err)
+
+			signedToken, err := SignJWT(context.Background(), signer, unsigned)
+			require.NoError(t, err)
+
+			token, err := jwt.Parse(signedToken, func(t *jwt.Token) (any, error) {
+				return signer.PublicKey(), nil
+			}, jwt.WithValidMethods([]string{name}))
+			require.NoError(t, err)
+
+			assert.Equal(t, "local-key", token.Header["kid"])
+			assert.Equal(t, "bar", token.Claims.(jwt.MapClaims)["foo"])
+		})
+	}
+
+	t.Run("rejects a key for a different curve", func(t *testing.T) {
+		alg, err := LookupSigningAlgorithm("ES384")
+		require.NoError(t, err)
+
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		_, err = NewLocalSigner(alg, privateKey, "local-key")
+		require.Error(t, err)
+	})
+}
+
+func Test_SelfTest(t *testing.T) {
+	alg, err := LookupSigningAlgorithm("ES256")
+	require.NoError(t, err)
+
+	privateKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	signer, err := NewLocalSigner(alg, privateKey, "local-key")
+	require.NoError(t, err)
+
+	assert.NoError(t, SelfTest(context.Background(), signer, privateKey.Public()))
+
+	otherKey, err := alg.GenerateKey()
+	require.NoError(t, err)
+	assert.ErrorContains(t, SelfTest(context.Background(), signer, otherKey.Public()), `self-test for kid "local-key" failed`)
+}
@@ -0,0 +1,144 @@
+package issuer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration is a length of time in the input, given as a number of seconds or
+// as a Go ("15m") or ISO 8601 ("PT1H") duration string. It's parsed when the
+// token is prepared, so a malformed value fails with INVALID_INPUT.
+type Duration string
+
+// Time is a point in time in the input, given as an RFC 3339 timestamp or as a
+// Go or ISO 8601 duration after the token is issued. Plain numbers are
+// rejected, since a Unix time and a number of seconds from now are too easily
+// mistaken for each other.
+type Time string
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalNumberOrString(data)
+	*d = Duration(value)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return marshalNumberOrString(string(d))
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	value, err := unmarshalNumberOrString(data)
+	*t = Time(value)
+	return err
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	return marshalNumberOrString(string(t))
+}
+
+// Parse returns the length of time.
+func (d Duration) Parse() (time.Duration, error) {
+	value := strings.TrimSpace(string(d))
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) || math.Abs(seconds) > math.MaxInt64/float64(time.Second) {
+			return 0, fmt.Errorf("%q is out of range", value)
+		}
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+
+	if strings.HasPrefix(strings.TrimPrefix(value, "-"), "P") {
+		return parseISO8601Duration(value)
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number of seconds or a duration like \"15m\" or \"PT15M\"", value)
+	}
+
+	return duration, nil
+}
+
+// Resolve returns the point in time, with durations counted from now.
+func (t Time) Resolve(now time.Time) (time.Time, error) {
+	value := strings.TrimSpace(string(t))
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Time{}, fmt.Errorf("%q is a plain number, which could be a Unix time or a number of seconds from now, use an RFC 3339 timestamp or a duration like \"1h\" instead", value)
+	}
+
+	offset, err := Duration(t).Parse()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 timestamp or a duration", string(t))
+	}
+
+	return now.Add(offset), nil
+}
+
+var iso8601DurationPattern = regexp.MustCompile(`^(-)?P(?:(\d+(?:[.,]\d+)?)Y)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)W)?(?:(\d+(?:[.,]\d+)?)D)?(?:T(?:(\d+(?:[.,]\d+)?)H)?(?:(\d+(?:[.,]\d+)?)M)?(?:(\d+(?:[.,]\d+)?)S)?)?$`)
+
+// parseISO8601Duration parses an ISO 8601 duration such as "PT1H30M" or
+// "P1D". Years and months aren't supported because their length varies.
+func parseISO8601Duration(value string) (time.Duration, error) {
+	match := iso8601DurationPattern.FindStringSubmatch(value)
+	if match == nil || strings.HasSuffix(value, "P") || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("%q is not an ISO 8601 duration", value)
+	}
+	if match[2] != "" || match[3] != "" {
+		return 0, fmt.Errorf("%q uses years or months, which aren't supported in ISO 8601 durations", value)
+	}
+
+	var total float64
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		part := match[i+4]
+		if part == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(strings.Replace(part, ",", ".", 1), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an ISO 8601 duration", value)
+		}
+		total += amount * float64(unit)
+	}
+
+	if total > math.MaxInt64 {
+		return 0, fmt.Errorf("%q is out of range", value)
+	}
+	if match[1] == "-" {
+		total = -total
+	}
+
+	return time.Duration(total), nil
+}
+
+// unmarshalNumberOrString returns a JSON string's value, or the text of any
+// other JSON value. Values that aren't numbers or strings are kept so that
+// parsing them fails with INVALID_INPUT rather than in the Lambda runtime.
+func unmarshalNumberOrString(data []byte) (string, error) {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var value string
+		err := json.Unmarshal(data, &value)
+		return value, err
+	}
+
+	return string(bytes.TrimSpace(data)), nil
+}
+
+// marshalNumberOrString writes numeric text as a JSON number, and anything
+// else as a string.
+func marshalNumberOrString(value string) ([]byte, error) {
+	if _, err := strconv.ParseFloat(value, 64); err == nil && json.Valid([]byte(value)) {
+		return []byte(value), nil
+	}
+
+	return json.Marshal(value)
+}
@@ -0,0 +1,73 @@
+package issuer
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Duration_Parse(t *testing.T) {
+	valid := map[Duration]time.Duration{
+		"60":        time.Minute,
+		"1.5":       1500 * time.Millisecond,
+		"-5":        -5 * time.Second,
+		"15m":       15 * time.Minute,
+		"1h30m":     90 * time.Minute,
+		"PT1H":      time.Hour,
+		"PT1H30M5S": time.Hour + 30*time.Minute + 5*time.Second,
+		"PT0.5S":    500 * time.Millisecond,
+		"P1DT2H":    26 * time.Hour,
+		"P2W":       14 * 24 * time.Hour,
+		"-PT5M":     -5 * time.Minute,
+	}
+	for input, expected := range valid {
+		duration, err := input.Parse()
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, duration, input)
+	}
+
+	for _, input := range []Duration{"", "P", "PT", "P1Y", "P1M", "PT1X", "15 minutes", "true", "1e300"} {
+		_, err := input.Parse()
+		assert.Error(t, err, input)
+	}
+}
+
+func Test_Time_Resolve(t *testing.T) {
+	now := time.Date(2024, 4, 23, 1, 2, 3, 0, time.UTC)
+
+	resolved, err := Time("2024-04-23T10:00:00+02:00").Resolve(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 23, 8, 0, 0, 0, time.UTC), resolved.UTC())
+
+	resolved, err = Time("1h").Resolve(now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), resolved)
+
+	resolved, err = Time("PT15M").Resolve(now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(15*time.Minute), resolved)
+
+	_, err = Time("2024-04-23").Resolve(now)
+	assert.EqualError(t, err, `"2024-04-23" is not an RFC 3339 timestamp or a duration`)
+
+	for _, input := range []Time{"3600", "1760000000", "1760000000000", "-60", "1.5"} {
+		_, err = input.Resolve(now)
+		assert.ErrorContains(t, err, "is a plain number", input)
+	}
+}
+
+func Test_Duration_JSON(t *testing.T) {
+	var input JWTIssuerFunctionInput
+	require.NoError(t, json.Unmarshal([]byte(`{"ttl":60,"clockSkew":"30s","notBefore":"2024-04-23T01:02:03Z","expiresAt":false}`), &input))
+	assert.Equal(t, Duration("60"), *input.TTL)
+	assert.Equal(t, Duration("30s"), *input.ClockSkew)
+	assert.Equal(t, Time("2024-04-23T01:02:03Z"), *input.NotBefore)
+	assert.Equal(t, Time("false"), *input.ExpiresAt)
+
+	output, err := json.Marshal(JWTIssuerFunctionInput{TTL: input.TTL, ClockSkew: input.ClockSkew})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ttl":60,"clockSkew":"30s"}`, string(output))
+}
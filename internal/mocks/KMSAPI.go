@@ -15,6 +15,80 @@ type KMSAPI struct {
 	mock.Mock
 }
 
+// CreateKey provides a mock function with given fields: _a0, _a1, _a2
+func (_m *KMSAPI) CreateKey(_a0 context.Context, _a1 *kms.CreateKeyInput, _a2 ...func(*kms.Options)) (*kms.CreateKeyOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateKey")
+	}
+
+	var r0 *kms.CreateKeyOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.CreateKeyInput, ...func(*kms.Options)) (*kms.CreateKeyOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.CreateKeyInput, ...func(*kms.Options)) *kms.CreateKeyOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*kms.CreateKeyOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *kms.CreateKeyInput, ...func(*kms.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DescribeKey provides a mock function with given fields: _a0, _a1, _a2
+func (_m *KMSAPI) DescribeKey(_a0 context.Context, _a1 *kms.DescribeKeyInput, _a2 ...func(*kms.Options)) (*kms.DescribeKeyOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DescribeKey")
+	}
+
+	var r0 *kms.DescribeKeyOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) (*kms.DescribeKeyOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) *kms.DescribeKeyOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*kms.DescribeKeyOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *kms.DescribeKeyInput, ...func(*kms.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetPublicKey provides a mock function with given fields: _a0, _a1, _a2
 func (_m *KMSAPI) GetPublicKey(_a0 context.Context, _a1 *kms.GetPublicKeyInput, _a2 ...func(*kms.Options)) (*kms.GetPublicKeyOutput, error) {
 	_va := make([]interface{}, len(_a2))
@@ -52,6 +126,43 @@ func (_m *KMSAPI) GetPublicKey(_a0 context.Context, _a1 *kms.GetPublicKeyInput,
 	return r0, r1
 }
 
+// ScheduleKeyDeletion provides a mock function with given fields: _a0, _a1, _a2
+func (_m *KMSAPI) ScheduleKeyDeletion(_a0 context.Context, _a1 *kms.ScheduleKeyDeletionInput, _a2 ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error) {
+	_va := make([]interface{}, len(_a2))
+	for _i := range _a2 {
+		_va[_i] = _a2[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, _a0, _a1)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ScheduleKeyDeletion")
+	}
+
+	var r0 *kms.ScheduleKeyDeletionOutput
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.ScheduleKeyDeletionInput, ...func(*kms.Options)) (*kms.ScheduleKeyDeletionOutput, error)); ok {
+		return rf(_a0, _a1, _a2...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *kms.ScheduleKeyDeletionInput, ...func(*kms.Options)) *kms.ScheduleKeyDeletionOutput); ok {
+		r0 = rf(_a0, _a1, _a2...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*kms.ScheduleKeyDeletionOutput)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *kms.ScheduleKeyDeletionInput, ...func(*kms.Options)) error); ok {
+		r1 = rf(_a0, _a1, _a2...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Sign provides a mock function with given fields: _a0, _a1, _a2
 func (_m *KMSAPI) Sign(_a0 context.Context, _a1 *kms.SignInput, _a2 ...func(*kms.Options)) (*kms.SignOutput, error) {
 	_va := make([]interface{}, len(_a2))